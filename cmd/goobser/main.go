@@ -0,0 +1,50 @@
+// Command goobser contains tools for working with the workshop's programs.
+//
+//	goobser top [-i interval] target...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "top", usage: "watch the request rate, errors, latency and runtime stats of one or more targets", run: top},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(flag.Args()[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, "Errored with: "+err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// bucket of a cumulative histogram.
+type bucket struct {
+	upper float64 // inclusive upper bound
+	count float64 // observations <= upper
+}
+
+// sample is what's known about a target at a point in time. Unknown values
+// are NaN.
+type sample struct {
+	at       time.Time
+	requests float64 // total
+	errors   float64 // total
+
+	// Latency is either known as a cumulative histogram (in seconds), from
+	// which quantiles are calculated over the time between samples, or as
+	// precalculated quantiles.
+	buckets   []bucket
+	quantiles map[float64]float64
+
+	goroutines float64
+	heapAlloc  float64 // bytes
+	sys        float64 // bytes
+	numGC      float64 // total
+}
+
+func newSample() *sample {
+	nan := math.NaN()
+	return &sample{
+		at:         time.Now(),
+		requests:   nan,
+		errors:     nan,
+		goroutines: nan,
+		heapAlloc:  nan,
+		sys:        nan,
+		numGC:      nan,
+	}
+}
+
+// scrape target, parsing /debug/vars output as expvar JSON and anything else
+// as the Prometheus text format.
+func scrape(c *http.Client, target string) (*sample, error) {
+	resp, err := c.Get(target)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+
+	if strings.HasSuffix(target, "/debug/vars") {
+		return parseExpvar(resp.Body)
+	}
+	return parsePrometheus(resp.Body)
+}
+
+// parseExpvar understands the vars published by the workshop's expvar servers:
+// Requests (an int or a timer object), Errors and memstats.
+func parseExpvar(r io.Reader) (*sample, error) {
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&vars); err != nil {
+		return nil, err
+	}
+	s := newSample()
+
+	if raw, ok := vars["Requests"]; ok {
+		var n float64
+		if err := json.Unmarshal(raw, &n); err == nil {
+			s.requests = n
+		} else {
+			var t struct {
+				Count  float64
+				P50    *float64
+				P90    *float64
+				P99    *float64
+				Window *struct {
+					P50, P90, P99 float64
+				}
+			}
+			if err := json.Unmarshal(raw, &t); err == nil {
+				s.requests = t.Count
+				switch {
+				case t.Window != nil: // expvarx.Timer, ns
+					s.quantiles = map[float64]float64{.5: t.Window.P50 / 1e9, .9: t.Window.P90 / 1e9, .99: t.Window.P99 / 1e9}
+				case t.P50 != nil && t.P90 != nil && t.P99 != nil:
+					s.quantiles = map[float64]float64{.5: *t.P50 / 1e9, .9: *t.P90 / 1e9, .99: *t.P99 / 1e9}
+				}
+			}
+		}
+	}
+	if raw, ok := vars["Errors"]; ok {
+		json.Unmarshal(raw, &s.errors)
+	}
+	if raw, ok := vars["memstats"]; ok {
+		var ms struct {
+			HeapAlloc, Sys, NumGC float64
+		}
+		if err := json.Unmarshal(raw, &ms); err == nil {
+			s.heapAlloc, s.sys, s.numGC = ms.HeapAlloc, ms.Sys, ms.NumGC
+		}
+	}
+	return s, nil
+}
+
+// parsePrometheus understands the metrics exposed by the workshop's
+// Prometheus servers plus the standard go_* metrics.
+func parsePrometheus(r io.Reader) (*sample, error) {
+	var p expfmt.TextParser
+	mfs, err := p.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, err
+	}
+	s := newSample()
+
+	if mf, ok := mfs["http_request_duration_seconds"]; ok && mf.GetType() == dto.MetricType_HISTOGRAM {
+		s.requests, s.errors = 0, 0
+		bounds := make(map[float64]float64)
+		for _, m := range mf.Metric {
+			h := m.GetHistogram()
+			n := float64(h.GetSampleCount())
+			s.requests += n
+			if isError(m) {
+				s.errors += n
+			}
+			inf := false
+			for _, b := range h.Bucket {
+				bounds[b.GetUpperBound()] += float64(b.GetCumulativeCount())
+				inf = inf || math.IsInf(b.GetUpperBound(), 1)
+			}
+			if !inf { // implicit
+				bounds[math.Inf(1)] += n
+			}
+		}
+		for u, c := range bounds {
+			s.buckets = append(s.buckets, bucket{upper: u, count: c})
+		}
+		sort.Slice(s.buckets, func(i, j int) bool { return s.buckets[i].upper < s.buckets[j].upper })
+	} else if mf, ok := mfs["http_requests_total"]; ok {
+		s.requests, s.errors = 0, 0
+		for _, m := range mf.Metric {
+			v := value(m)
+			s.requests += v
+			if isError(m) {
+				s.errors += v
+			}
+		}
+	}
+	if mf, ok := mfs["http_errors_total"]; ok {
+		s.errors = sum(mf)
+	}
+
+	if mf, ok := mfs["go_goroutines"]; ok {
+		s.goroutines = sum(mf)
+	}
+	if mf, ok := mfs["go_memstats_heap_alloc_bytes"]; ok {
+		s.heapAlloc = sum(mf)
+	}
+	if mf, ok := mfs["go_memstats_sys_bytes"]; ok {
+		s.sys = sum(mf)
+	}
+	if mf, ok := mfs["go_gc_duration_seconds"]; ok && len(mf.Metric) > 0 {
+		s.numGC = float64(mf.Metric[0].GetSummary().GetSampleCount())
+	}
+	return s, nil
+}
+
+// isError reports whether the metric has a code label >= 400.
+func isError(m *dto.Metric) bool {
+	for _, l := range m.Label {
+		if l.GetName() == "code" {
+			code, err := strconv.Atoi(l.GetValue())
+			return err == nil && code >= 400
+		}
+	}
+	return false
+}
+
+func value(m *dto.Metric) float64 {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return math.NaN()
+}
+
+func sum(mf *dto.MetricFamily) float64 {
+	var t float64
+	for _, m := range mf.Metric {
+		t += value(m)
+	}
+	return t
+}
+
+// quantile estimates the q-quantile of the cumulative histogram bs the same
+// way Prometheus' histogram_quantile does: by linear interpolation within the
+// bucket the quantile falls in.
+func quantile(q float64, bs []bucket) float64 {
+	if len(bs) == 0 || bs[len(bs)-1].count <= 0 {
+		return math.NaN()
+	}
+	rank := q * bs[len(bs)-1].count
+	i := sort.Search(len(bs), func(i int) bool { return bs[i].count >= rank })
+	if i >= len(bs) {
+		i = len(bs) - 1
+	}
+	if math.IsInf(bs[i].upper, 1) { // can't interpolate into +Inf
+		if i == 0 {
+			return math.NaN()
+		}
+		return bs[i-1].upper
+	}
+	var lower, below float64
+	if i > 0 {
+		lower, below = bs[i-1].upper, bs[i-1].count
+	}
+	if bs[i].count == below {
+		return bs[i].upper
+	}
+	return lower + (bs[i].upper-lower)*(rank-below)/(bs[i].count-below)
+}
+
+// delta returns the histogram of observations made between prev and cur.
+func delta(prev, cur []bucket) []bucket {
+	if len(prev) != len(cur) {
+		return cur
+	}
+	d := make([]bucket, len(cur))
+	for i := range cur {
+		if prev[i].upper != cur[i].upper || cur[i].count < prev[i].count { // changed or reset
+			return cur
+		}
+		d[i] = bucket{upper: cur[i].upper, count: cur[i].count - prev[i].count}
+	}
+	return d
+}
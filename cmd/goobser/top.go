@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const topUsage = `Usage: goobser top [-i interval] target...
+
+Polls each target and shows its request rate, error ratio, latency
+percentiles, goroutines and memory stats side by side. A target is the URL of
+a Prometheus /metrics or an expvar /debug/vars endpoint. Targets without a
+path default to /metrics, so servicea and serviceb can be watched with:
+
+  goobser top localhost:8080 localhost:8081
+`
+
+const columnWidth = 24
+
+// target is something being watched.
+type target struct {
+	url  string
+	prev *sample
+	cur  *sample
+	err  error
+}
+
+func top(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	interval := fs.Duration("i", 2*time.Second, "polling interval")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, topUsage) }
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	targets := make([]*target, 0, fs.NArg())
+	for _, a := range fs.Args() {
+		u, err := targetURL(a)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, &target{url: u})
+	}
+
+	c := http.Client{Timeout: *interval} // always set sensible values, never trust the defaults
+	for {
+		poll(&c, targets)
+		os.Stdout.Write(render(targets, time.Now()))
+		time.Sleep(*interval)
+	}
+}
+
+// targetURL adds the scheme and path to a target if they're missing.
+func targetURL(t string) (string, error) {
+	if !strings.Contains(t, "://") {
+		t = "http://" + t
+	}
+	u, err := url.Parse(t)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/metrics"
+	}
+	return u.String(), nil
+}
+
+// poll all of the targets concurrently.
+func poll(c *http.Client, targets []*target) {
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			s, err := scrape(c, t.url)
+			t.err = err
+			if err != nil {
+				return
+			}
+			t.prev, t.cur = t.cur, s
+		}(t)
+	}
+	wg.Wait()
+}
+
+type row struct {
+	name  string
+	value func(t *target) string
+}
+
+var rows = []row{
+	{"req/s", func(t *target) string { return number(t.rate(func(s *sample) float64 { return s.requests })) }},
+	{"errors", func(t *target) string { return percent(t.errorRatio()) }},
+	{"p50", func(t *target) string { return seconds(t.quantile(.5)) }},
+	{"p90", func(t *target) string { return seconds(t.quantile(.9)) }},
+	{"p99", func(t *target) string { return seconds(t.quantile(.99)) }},
+	{"goroutines", func(t *target) string { return number(t.cur.goroutines) }},
+	{"heap alloc", func(t *target) string { return bytesize(t.cur.heapAlloc) }},
+	{"sys", func(t *target) string { return bytesize(t.cur.sys) }},
+	{"gc/s", func(t *target) string { return number(t.rate(func(s *sample) float64 { return s.numGC })) }},
+}
+
+// render the targets as a table, one column per target, preceded by the ANSI
+// sequence to clear the terminal.
+func render(targets []*target, now time.Time) []byte {
+	var b bytes.Buffer
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "goobser top - %s\n\n", now.Format("15:04:05"))
+
+	fmt.Fprintf(&b, "%-12s", "")
+	for _, t := range targets {
+		fmt.Fprintf(&b, "%*s", columnWidth, truncate(strings.TrimPrefix(t.url, "http://"), columnWidth-1))
+	}
+	b.WriteString("\n")
+
+	for _, r := range rows {
+		fmt.Fprintf(&b, "%-12s", r.name)
+		for _, t := range targets {
+			v := "-"
+			if t.cur != nil {
+				v = r.value(t)
+			}
+			fmt.Fprintf(&b, "%*s", columnWidth, v)
+		}
+		b.WriteString("\n")
+	}
+
+	for _, t := range targets {
+		if t.err != nil {
+			fmt.Fprintf(&b, "\n%s: %v", t.url, t.err)
+		}
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// rate per second of the counter returned by f between the last two samples.
+func (t *target) rate(f func(*sample) float64) float64 {
+	if t.prev == nil {
+		return math.NaN()
+	}
+	d := f(t.cur) - f(t.prev)
+	secs := t.cur.at.Sub(t.prev.at).Seconds()
+	if d < 0 || secs <= 0 { // reset
+		return math.NaN()
+	}
+	return d / secs
+}
+
+func (t *target) errorRatio() float64 {
+	if t.prev == nil {
+		return math.NaN()
+	}
+	reqs := t.cur.requests - t.prev.requests
+	if reqs <= 0 {
+		return math.NaN()
+	}
+	return (t.cur.errors - t.prev.errors) / reqs
+}
+
+// quantile of the latency, over the time between the last two samples when
+// it's calculated from a histogram.
+func (t *target) quantile(q float64) float64 {
+	if t.cur.quantiles != nil {
+		if v, ok := t.cur.quantiles[q]; ok {
+			return v
+		}
+		return math.NaN()
+	}
+	if t.prev == nil {
+		return quantile(q, t.cur.buckets)
+	}
+	return quantile(q, delta(t.prev.buckets, t.cur.buckets))
+}
+
+func number(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+func percent(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", v*100)
+}
+
+func seconds(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return time.Duration(v * float64(time.Second)).Round(10 * time.Microsecond).String()
+}
+
+func bytesize(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	const unit = 1024
+	if v < unit {
+		return fmt.Sprintf("%.0fB", v)
+	}
+	div, exp := float64(unit), 0
+	for n := v / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", v/div, "KMGTPE"[exp])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
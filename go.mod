@@ -4,10 +4,12 @@ go 1.12
 
 require (
 	contrib.go.opencensus.io/exporter/jaeger v0.1.0
-	github.com/gogo/protobuf v1.2.0 // indirect
+	contrib.go.opencensus.io/exporter/prometheus v0.1.0
 	github.com/google/uuid v1.1.1
 	github.com/pkg/errors v0.8.1
-	github.com/prometheus/client_golang v1.0.0
-	github.com/sirupsen/logrus v1.2.0
+	github.com/prometheus/client_golang v1.4.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.9.1
+	github.com/sirupsen/logrus v1.4.2
 	go.opencensus.io v0.22.0
 )
@@ -0,0 +1,295 @@
+// Package baggage propagates OpenCensus tags across services in the W3C
+// baggage header (https://www.w3.org/TR/baggage/), so that dimensions set at
+// the edge, a tenant or customer tier for example, reach the metrics and spans
+// of the services downstream without every hop passing them explicitly.
+//
+// Only the tags whose keys are allowed by a Policy are propagated, and keys
+// that look sensitive never are.
+package baggage
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// Header is the W3C baggage header.
+const Header = "baggage"
+
+// Defaults used when a Policy doesn't specify otherwise.
+const (
+	DefaultKeys       = "tenant,tier"
+	DefaultMaxEntries = 16
+	DefaultMaxBytes   = 8192 // the minimum a W3C baggage implementation must propagate
+)
+
+// DefaultDeny are the substrings of keys that are never propagated.
+var DefaultDeny = []string{"auth", "cookie", "credential", "password", "secret", "session", "token"}
+
+// maxValueLength is the longest tag value OpenCensus accepts.
+const maxValueLength = 255
+
+// Keys are tag keys. Their text form is a comma separated list of key names.
+type Keys []tag.Key
+
+// ParseKeys parses the text form of Keys.
+func ParseKeys(s string) (Keys, error) {
+	var ks Keys
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		k, err := tag.NewKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("baggage: invalid key %q: %v", name, err)
+		}
+		ks = append(ks, k)
+	}
+	return ks, nil
+}
+
+func (ks Keys) String() string {
+	names := make([]string, len(ks))
+	for i, k := range ks {
+		names[i] = k.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Set implements flag.Value.
+func (ks *Keys) Set(s string) error {
+	v, err := ParseKeys(s)
+	if err != nil {
+		return err
+	}
+	*ks = v
+	return nil
+}
+
+// Policy decides which tags are propagated.
+type Policy struct {
+	// Keys are the allowed tag keys. Tags with other keys are neither sent nor
+	// accepted.
+	Keys Keys
+
+	// Deny are case insensitive substrings of keys that are never propagated,
+	// even when allowed by Keys. Defaults to DefaultDeny.
+	Deny []string
+
+	// MaxEntries is the maximum number of tags propagated. Defaults to
+	// DefaultMaxEntries.
+	MaxEntries int
+
+	// MaxBytes is the maximum length of the baggage header, tags that don't
+	// fit are dropped. Defaults to DefaultMaxBytes.
+	MaxBytes int
+}
+
+// EnvKeys is the environment variable read by RegisterFlags to override the
+// default of the -baggage-keys flag.
+const EnvKeys = "BAGGAGE_KEYS"
+
+// RegisterFlags registers the -baggage-keys flag, setting p.Keys, on fs. It
+// defaults to the value of EnvKeys, or to DefaultKeys if it's unset. An
+// invalid environment variable is an error.
+func (p *Policy) RegisterFlags(fs *flag.FlagSet) error {
+	def := DefaultKeys
+	if v, ok := os.LookupEnv(EnvKeys); ok {
+		def = v
+	}
+	if err := p.Keys.Set(def); err != nil {
+		return fmt.Errorf("%s: %v", EnvKeys, err)
+	}
+	fs.Var(&p.Keys, "baggage-keys", "comma separated tag `keys` propagated in the baggage header")
+	return nil
+}
+
+// allowed returns the allowed key named name.
+func (p *Policy) allowed(name string) (tag.Key, bool) {
+	deny := p.Deny
+	if deny == nil {
+		deny = DefaultDeny
+	}
+	lower := strings.ToLower(name)
+	for _, d := range deny {
+		if strings.Contains(lower, strings.ToLower(d)) {
+			return tag.Key{}, false
+		}
+	}
+	for _, k := range p.Keys {
+		if k.Name() == name {
+			return k, true
+		}
+	}
+	return tag.Key{}, false
+}
+
+func (p *Policy) limits() (entries, bytes int) {
+	entries, bytes = p.MaxEntries, p.MaxBytes
+	if entries <= 0 {
+		entries = DefaultMaxEntries
+	}
+	if bytes <= 0 {
+		bytes = DefaultMaxBytes
+	}
+	return entries, bytes
+}
+
+// Encode returns the baggage header value for the allowed tags of m, "" if
+// there are none.
+func (p *Policy) Encode(m *tag.Map) string {
+	if m == nil {
+		return ""
+	}
+	maxEntries, maxBytes := p.limits()
+
+	var b strings.Builder
+	n := 0
+	for _, k := range p.Keys {
+		if n == maxEntries {
+			break
+		}
+		v, ok := m.Value(k)
+		if !ok {
+			continue
+		}
+		if _, ok := p.allowed(k.Name()); !ok {
+			continue
+		}
+		member := k.Name() + "=" + url.PathEscape(v)
+		if b.Len() > 0 {
+			member = "," + member
+		}
+		if b.Len()+len(member) > maxBytes {
+			continue
+		}
+		b.WriteString(member)
+		n++
+	}
+	return b.String()
+}
+
+// Decode returns the allowed tags of the baggage header value h. Malformed
+// list members, and those over the limits, are ignored, as are the members'
+// properties.
+func (p *Policy) Decode(h string) []tag.Mutator {
+	maxEntries, maxBytes := p.limits()
+	if len(h) > maxBytes { // keep the members ending within maxBytes
+		h = h[:strings.LastIndexByte(h[:maxBytes+1], ',')+1]
+	}
+
+	var muts []tag.Mutator
+	for _, member := range strings.Split(h, ",") {
+		if len(muts) == maxEntries {
+			break
+		}
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+		i := strings.IndexByte(member, '=')
+		if i < 0 {
+			continue
+		}
+		k, ok := p.allowed(strings.TrimSpace(member[:i]))
+		if !ok {
+			continue
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(member[i+1:]))
+		if err != nil || !validValue(v) {
+			continue
+		}
+		muts = append(muts, tag.Upsert(k, v))
+	}
+	return muts
+}
+
+// validValue reports whether v is a valid tag value: printable ASCII, no
+// longer than maxValueLength.
+func validValue(v string) bool {
+	if len(v) > maxValueLength {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < ' ' || v[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler is a http.Handler that adds the allowed tags of the incoming
+// request's baggage header to the request's context, so that they are
+// recorded with its metrics, and as attributes of its span. Use it inside
+// ochttp.Handler so that the request's span has been started.
+type Handler struct {
+	// Handler is the handler that is called with the tags in the request's
+	// context.
+	Handler http.Handler
+
+	// Policy decides which tags are accepted.
+	Policy *Policy
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	muts := h.Policy.Decode(strings.Join(r.Header[http.CanonicalHeaderKey(Header)], ","))
+	if len(muts) == 0 {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	ctx, err := tag.New(r.Context(), muts...)
+	if err != nil { // the tags were validated by Decode
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		m := tag.FromContext(ctx)
+		for _, k := range h.Policy.Keys {
+			if v, ok := m.Value(k); ok {
+				span.AddAttributes(trace.StringAttribute(k.Name(), v))
+			}
+		}
+	}
+	h.Handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Transport is a http.RoundTripper that sets the baggage header of outbound
+// requests from the allowed tags carried by their context, replacing any
+// baggage header already set.
+type Transport struct {
+	// Base is the RoundTripper used to make the request. Defaults to
+	// http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Policy decides which tags are sent.
+	Policy *Policy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	b := t.Policy.Encode(tag.FromContext(req.Context()))
+	if b == "" {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request, so set the header on a copy.
+	r := req.WithContext(req.Context())
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set(Header, b)
+	return base.RoundTrip(r)
+}
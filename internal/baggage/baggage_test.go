@@ -0,0 +1,156 @@
+package baggage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opencensus.io/tag"
+)
+
+var (
+	keyTenant  = tag.MustNewKey("tenant")
+	keyTier    = tag.MustNewKey("tier")
+	keySession = tag.MustNewKey("session_id")
+)
+
+// tags returns the tags of ctx with keys ks, by name.
+func tags(ctx context.Context, ks ...tag.Key) map[string]string {
+	m := tag.FromContext(ctx)
+	got := make(map[string]string)
+	for _, k := range ks {
+		if v, ok := m.Value(k); ok {
+			got[k.Name()] = v
+		}
+	}
+	return got
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		header string
+		want   map[string]string
+	}{
+		{"one", Policy{}, "tenant=acme", map[string]string{"tenant": "acme"}},
+		{"two with spaces and properties", Policy{}, "tenant=acme%20corp;prop=1, tier = gold", map[string]string{"tenant": "acme corp", "tier": "gold"}},
+		{"last one wins", Policy{}, "tenant=a,tenant=b", map[string]string{"tenant": "b"}},
+		{"not allowed", Policy{}, "other=x,tier=gold", map[string]string{"tier": "gold"}},
+		{"denied even if allowed", Policy{}, "session_id=abc,tier=gold", map[string]string{"tier": "gold"}},
+		{"custom deny", Policy{Deny: []string{"TIER"}}, "session_id=abc,tier=gold", map[string]string{"session_id": "abc"}},
+		{"no value", Policy{}, "tenant,tier=gold", map[string]string{"tier": "gold"}},
+		{"empty value", Policy{}, "tenant=", map[string]string{"tenant": ""}},
+		{"bad escape", Policy{}, "tenant=%zz,tier=gold", map[string]string{"tier": "gold"}},
+		{"not ascii", Policy{}, "tenant=caf%C3%A9", map[string]string{}},
+		{"control character", Policy{}, "tenant=a%0Ab", map[string]string{}},
+		{"longest value", Policy{}, "tenant=" + strings.Repeat("a", 255), map[string]string{"tenant": strings.Repeat("a", 255)}},
+		{"value too long", Policy{}, "tenant=" + strings.Repeat("a", 256), map[string]string{}},
+		{"max entries", Policy{MaxEntries: 1}, "tenant=a,tier=b", map[string]string{"tenant": "a"}},
+		{"max entries count accepted members", Policy{MaxEntries: 1}, "other=x,tier=b", map[string]string{"tier": "b"}},
+		{"max bytes at a member's end", Policy{MaxBytes: 8}, "tenant=a,tier=b", map[string]string{"tenant": "a"}},
+		{"max bytes within a member", Policy{MaxBytes: 10}, "tenant=a,tier=b", map[string]string{"tenant": "a"}},
+		{"max bytes within the first member", Policy{MaxBytes: 5}, "tenant=a,tier=b", map[string]string{}},
+		{"max bytes not reached", Policy{MaxBytes: 15}, "tenant=a,tier=b", map[string]string{"tenant": "a", "tier": "b"}},
+		{"empty", Policy{}, "", map[string]string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.policy.Keys = Keys{keyTenant, keyTier, keySession}
+			ctx, err := tag.New(context.Background(), tc.policy.Decode(tc.header)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tags(ctx, tc.policy.Keys...); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Decode(%q) = %v, want %v", tc.header, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	ctx, err := tag.New(context.Background(),
+		tag.Upsert(keyTenant, "acme corp,x"),
+		tag.Upsert(keyTier, "gold"),
+		tag.Upsert(keySession, "abc"),
+		tag.Upsert(tag.MustNewKey("other"), "x"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := tag.FromContext(ctx)
+
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{"allowed keys, escaped", Policy{}, "tenant=acme%20corp%2Cx,tier=gold"},
+		{"key order", Policy{Keys: Keys{keyTier, keyTenant}}, "tier=gold,tenant=acme%20corp%2Cx"},
+		{"denied", Policy{Keys: Keys{keySession, keyTier}}, "tier=gold"},
+		{"nothing allowed", Policy{Keys: Keys{}}, ""},
+		{"max entries", Policy{MaxEntries: 1}, "tenant=acme%20corp%2Cx"},
+		{"max bytes skips what doesn't fit", Policy{MaxBytes: 12}, "tier=gold"},
+		{"max bytes exactly", Policy{MaxBytes: len("tenant=acme%20corp%2Cx,tier=gold")}, "tenant=acme%20corp%2Cx,tier=gold"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.policy.Keys == nil {
+				tc.policy.Keys = Keys{keyTenant, keyTier}
+			}
+			if got := tc.policy.Encode(m); got != tc.want {
+				t.Errorf("Encode() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if got := (&Policy{Keys: Keys{keyTenant}}).Encode(nil); got != "" {
+		t.Errorf("Encode(nil) = %q, want \"\"", got)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	ks, err := ParseKeys(" tenant, ,tier ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ks.String(); got != "tenant,tier" {
+		t.Errorf("ParseKeys(...).String() = %q, want %q", got, "tenant,tier")
+	}
+	if _, err := ParseKeys("tenant,café"); err == nil {
+		t.Error("ParseKeys with a non ASCII key didn't fail")
+	}
+}
+
+func TestHandlerAndTransport(t *testing.T) {
+	p := &Policy{Keys: Keys{keyTenant, keyTier}}
+
+	var got map[string]string
+	srv := httptest.NewServer(&Handler{
+		Policy: p,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = tags(r.Context(), keyTenant, keyTier)
+		}),
+	})
+	defer srv.Close()
+
+	ctx, err := tag.New(context.Background(), tag.Upsert(keyTenant, "acme"), tag.Upsert(keyTier, "gold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set(Header, "stale=1")
+	res, err := (&http.Client{Transport: &Transport{Policy: p}}).Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if want := map[string]string{"tenant": "acme", "tier": "gold"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handler got tags %v, want %v", got, want)
+	}
+	if h := req.Header.Get(Header); h != "stale=1" {
+		t.Errorf("Transport modified the request's header to %q", h)
+	}
+}
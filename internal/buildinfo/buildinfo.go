@@ -0,0 +1,194 @@
+// Package buildinfo describes the running program: what was built, from which
+// commit, with which Go version, where it's running, since when and with what
+// configuration. The same Info is exposed as a Prometheus info metric, an
+// expvar and Jaeger process tags, so metrics, /debug/vars and traces agree.
+package buildinfo
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"contrib.go.opencensus.io/exporter/jaeger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version and Commit are meant to be set by the linker, e.g.:
+//
+//	go build -ldflags "-X github.com/freeformz/goobser/internal/buildinfo.Version=v1.2.3 -X github.com/freeformz/goobser/internal/buildinfo.Commit=$(git rev-parse HEAD)"
+//
+// When Version isn't set the main module's version is used instead.
+var (
+	Version string
+	Commit  string
+)
+
+// Info about the running program.
+type Info struct {
+	Version   string
+	Commit    string
+	Module    string // path of the main module
+	GoVersion string
+	Hostname  string
+	Start     time.Time
+
+	// Config is the effective configuration. Its keys are used as
+	// Prometheus label names, so they must be valid ones, other than the
+	// reserved ones (see New).
+	Config map[string]string
+
+	desc *prometheus.Desc
+}
+
+// reserved are the labels of the info metric, and the Jaeger process tags,
+// config keys can't use.
+var reserved = map[string]bool{
+	"version":    true,
+	"commit":     true,
+	"module":     true,
+	"go_version": true,
+	"hostname":   true,
+	"start_time": true,
+}
+
+// New collects the Info of the running program, with config as the effective
+// configuration. It's an error for a config key not to be a valid Prometheus
+// label name, or to be one of version, commit, module, go_version, hostname or
+// start_time.
+func New(config map[string]string) (*Info, error) {
+	for k := range config {
+		if !validLabel(k) {
+			return nil, fmt.Errorf("buildinfo: config key %q isn't a valid label name", k)
+		}
+		if reserved[k] {
+			return nil, fmt.Errorf("buildinfo: config key %q is reserved", k)
+		}
+	}
+
+	i := &Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Start:     time.Now(),
+		Config:    config,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		i.Module = bi.Main.Path
+		if i.Version == "" {
+			i.Version = bi.Main.Version
+		}
+	}
+	if h, err := os.Hostname(); err == nil {
+		i.Hostname = h
+	}
+
+	labels := prometheus.Labels{
+		"version":    i.Version,
+		"commit":     i.Commit,
+		"module":     i.Module,
+		"go_version": i.GoVersion,
+		"hostname":   i.Hostname,
+	}
+	for k, v := range config {
+		labels[k] = v
+	}
+	i.desc = prometheus.NewDesc(
+		"program_info",
+		"Info about the program. Always 1.",
+		nil, labels,
+	)
+	return i, nil
+}
+
+// validLabel reports whether name is a valid Prometheus label name, not
+// reserved for internal use.
+func validLabel(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Flags adds the values of the flags of fs to config, keyed by the flag's name
+// with its dashes replaced by underscores, and returns config. Call it once fs
+// is parsed, so config holds the effective values.
+func Flags(fs *flag.FlagSet, config map[string]string) map[string]string {
+	if config == nil {
+		config = make(map[string]string)
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		config[strings.Replace(f.Name, "-", "_", -1)] = f.Value.String()
+	})
+	return config
+}
+
+// Describe implements prometheus.Collector.
+func (i *Info) Describe(ch chan<- *prometheus.Desc) {
+	ch <- i.desc
+}
+
+// Collect implements prometheus.Collector.
+func (i *Info) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(i.desc, prometheus.GaugeValue, 1)
+}
+
+// String implements expvar.Var.
+func (i *Info) String() string {
+	b, err := json.Marshal(struct {
+		Version   string
+		Commit    string
+		Module    string
+		GoVersion string
+		Hostname  string
+		Start     time.Time
+		Uptime    float64 // seconds
+		Config    map[string]string
+	}{
+		Version:   i.Version,
+		Commit:    i.Commit,
+		Module:    i.Module,
+		GoVersion: i.GoVersion,
+		Hostname:  i.Hostname,
+		Start:     i.Start,
+		Uptime:    time.Since(i.Start).Seconds(),
+		Config:    i.Config,
+	})
+	if err != nil {
+		return strconv.Quote(err.Error())
+	}
+	return string(b)
+}
+
+// JaegerTags returns i as Jaeger process tags.
+func (i *Info) JaegerTags() []jaeger.Tag {
+	tags := []jaeger.Tag{
+		jaeger.StringTag("version", i.Version),
+		jaeger.StringTag("commit", i.Commit),
+		jaeger.StringTag("module", i.Module),
+		jaeger.StringTag("go_version", i.GoVersion),
+		jaeger.StringTag("hostname", i.Hostname),
+		jaeger.StringTag("start_time", i.Start.Format(time.RFC3339)),
+	}
+
+	keys := make([]string, 0, len(i.Config))
+	for k := range i.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, jaeger.StringTag(k, i.Config[k]))
+	}
+	return tags
+}
@@ -0,0 +1,49 @@
+package buildinfo
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestNewConfigKeys(t *testing.T) {
+	for _, tc := range []struct {
+		key string
+		ok  bool
+	}{
+		{"port", true},
+		{"_port", true},
+		{"serviceb_url", true},
+		{"Port2", true},
+		{"", false},
+		{"2port", false},
+		{"trace-tail", false},
+		{"__port", false},
+		{"version", false},
+		{"go_version", false},
+		{"start_time", false},
+	} {
+		_, err := New(map[string]string{tc.key: "x"})
+		if (err == nil) != tc.ok {
+			t.Errorf("New with config key %q: err = %v, want ok %v", tc.key, err, tc.ok)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("trace-exporter", "jaeger", "")
+	fs.Bool("trace-tail", false, "")
+	if err := fs.Parse([]string{"-trace-tail"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := Flags(fs, map[string]string{"port": "8080"})
+	want := map[string]string{"port": "8080", "trace_exporter": "jaeger", "trace_tail": "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Flags() = %v, want %v", got, want)
+	}
+	if _, err := New(got); err != nil {
+		t.Errorf("New(Flags()) failed: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Package expvarprom exports expvar variables as Prometheus metrics, so a
+// program instrumented with expvar can be moved to Prometheus one variable at
+// a time, without instrumenting everything twice in the mean time.
+package expvarprom
+
+import (
+	"encoding/json"
+	"expvar"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Mapping describes how an expvar value is converted to a Prometheus metric.
+type Mapping struct {
+	// Name of the metric.
+	Name string
+
+	// Help for the metric. Defaults to a description of the expvar path.
+	Help string
+
+	// Type of the metric: prometheus.CounterValue, prometheus.GaugeValue or
+	// prometheus.UntypedValue. Defaults to untyped.
+	Type prometheus.ValueType
+
+	// Labels names the keys of a map (or JSON object) value, one label per
+	// level of nesting. For example an expvar.Map of route to an expvar.Map
+	// of status code to count would use []string{"route", "code"}. Values
+	// nested more or less deeply than len(Labels) are ignored.
+	Labels []string
+
+	// ConstLabels are added to every metric.
+	ConstLabels prometheus.Labels
+
+	// Scale, if not zero, multiplies values. For example 1e-9 converts
+	// nanoseconds to seconds.
+	Scale float64
+}
+
+// Collector is a prometheus.Collector that reports expvar variables. Only
+// numeric values are reported.
+type Collector struct {
+	// Mappings of expvar paths to metrics. A path is the name of an expvar
+	// variable, optionally followed by a dot separated path into its JSON
+	// value, just like expvarmon: "Errors", "Requests.Count",
+	// "memstats.HeapAlloc".
+	Mappings map[string]Mapping
+
+	// Prefix, if not empty, exports every numeric value not covered by
+	// Mappings as an untyped metric named Prefix followed by its path, with
+	// characters Prometheus doesn't allow replaced by '_'.
+	Prefix string
+}
+
+// Describe doesn't describe anything, which makes Collector an unchecked
+// collector: the metrics it reports depend on what is published to expvar at
+// the time of collection.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the expvar variables.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !c.wanted(kv.Key) {
+			return
+		}
+		d := json.NewDecoder(strings.NewReader(kv.Value.String()))
+		d.UseNumber()
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return
+		}
+		c.collect(ch, kv.Key, v)
+	})
+}
+
+// wanted reports whether anything would be reported for the variable named
+// name, avoiding calling String on variables like memstats unless necessary.
+func (c *Collector) wanted(name string) bool {
+	if c.Prefix != "" {
+		return true
+	}
+	for path := range c.Mappings {
+		if path == name || strings.HasPrefix(path, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric, path string, v interface{}) {
+	if m, ok := c.Mappings[path]; ok {
+		help := m.Help
+		if help == "" {
+			help = "expvar " + path
+		}
+		typ := m.Type
+		if typ == 0 {
+			typ = prometheus.UntypedValue
+		}
+		desc := prometheus.NewDesc(m.Name, help, m.Labels, m.ConstLabels)
+		emit(ch, desc, typ, m.Scale, len(m.Labels), v, nil)
+		return
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range v {
+			c.collect(ch, path+"."+k, vv)
+		}
+	case json.Number:
+		if c.Prefix != "" {
+			desc := prometheus.NewDesc(c.Prefix+sanitize(path), "expvar "+path, nil, nil)
+			emit(ch, desc, prometheus.UntypedValue, 0, 0, v, nil)
+		}
+	}
+}
+
+// emit sends the metric(s) for v, descending into maps to collect depth label
+// values.
+func emit(ch chan<- prometheus.Metric, desc *prometheus.Desc, typ prometheus.ValueType, scale float64, depth int, v interface{}, lvs []string) {
+	switch v := v.(type) {
+	case json.Number:
+		if len(lvs) != depth {
+			return
+		}
+		f, err := v.Float64()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			return
+		}
+		if scale != 0 {
+			f *= scale
+		}
+		m, err := prometheus.NewConstMetric(desc, typ, f, lvs...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- m
+	case map[string]interface{}:
+		if len(lvs) >= depth {
+			return
+		}
+		for k, vv := range v {
+			emit(ch, desc, typ, scale, depth, vv, append(lvs[:len(lvs):len(lvs)], k))
+		}
+	}
+}
+
+// sanitize replaces characters not allowed in metric names with '_'.
+func sanitize(path string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, path)
+}
@@ -0,0 +1,116 @@
+package expvarx
+
+import (
+	"math"
+	"math/bits"
+)
+
+// histogram is a log-linear histogram of non negative int64 values, similar to
+// an HDR histogram. Every power of 2 is split into subCount equally sized
+// buckets, so any value is recorded with a relative error of at most
+// 1/subCount (~3%), while using a fixed amount of memory regardless of the
+// number or range of values recorded.
+type histogram struct {
+	counts   [numBuckets]uint64
+	count    uint64
+	sum      int64
+	min, max int64
+}
+
+const (
+	subBits    = 5
+	subCount   = 1 << subBits
+	numBuckets = (64 - subBits) * subCount // enough for any value <= math.MaxInt64
+)
+
+func bucketIndex(v int64) int {
+	if v < subCount {
+		return int(v)
+	}
+	shift := uint(bits.Len64(uint64(v)) - subBits - 1)
+	return int(shift+1)*subCount + int(v>>shift) - subCount
+}
+
+// bucketValue returns the midpoint of the range of values that are recorded
+// in bucket i.
+func bucketValue(i int) int64 {
+	if i < subCount {
+		return int64(i)
+	}
+	shift := uint(i/subCount - 1)
+	lower := int64(i%subCount+subCount) << shift
+	return lower + (int64(1)<<shift)/2
+}
+
+func (h *histogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.counts[bucketIndex(v)]++
+	h.count++
+	if h.sum > math.MaxInt64-v { // saturate instead of wrapping
+		h.sum = math.MaxInt64
+	} else {
+		h.sum += v
+	}
+}
+
+func (h *histogram) reset() {
+	*h = histogram{}
+}
+
+func (h *histogram) merge(o *histogram) {
+	if o.count == 0 {
+		return
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	if h.sum > math.MaxInt64-o.sum {
+		h.sum = math.MaxInt64
+	} else {
+		h.sum += o.sum
+	}
+}
+
+// quantile returns the value at quantile q (0 < q <= 1) of the recorded values.
+func (h *histogram) quantile(q float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	switch { // the extremes are known exactly
+	case rank <= 1:
+		return h.min
+	case rank >= h.count:
+		return h.max
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := bucketValue(i)
+			switch { // the bucket's midpoint may be outside of what was recorded
+			case v < h.min:
+				v = h.min
+			case v > h.max:
+				v = h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,149 @@
+package expvarx
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBucketIndex(t *testing.T) {
+	for _, tc := range []struct {
+		v     int64
+		index int
+		value int64 // bucketValue(index)
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{31, 31, 31}, // values below subCount have a bucket each
+		{32, 32, 32},
+		{63, 63, 63},
+		{64, 64, 65}, // from 64 buckets are 2 wide
+		{65, 64, 65},
+		{66, 65, 67},
+		{127, 95, 127},
+		{128, 96, 130}, // from 128 buckets are 4 wide
+		{1000, 190, 1000},
+		{math.MaxInt64, numBuckets - 1, 9151314442816847872},
+	} {
+		i := bucketIndex(tc.v)
+		if i != tc.index {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tc.v, i, tc.index)
+			continue
+		}
+		if v := bucketValue(i); v != tc.value {
+			t.Errorf("bucketValue(%d) = %d, want %d", i, v, tc.value)
+		}
+	}
+}
+
+func TestBucketRelativeError(t *testing.T) {
+	prev := -1
+	for _, v := range []int64{0, 1, 2, 31, 32, 33, 63, 64, 65, 100, 1e3, 1e6 - 1, 1e6, 1e9, 1e12, 1e15, 1e18, math.MaxInt64 / 2, math.MaxInt64 - 1, math.MaxInt64} {
+		i := bucketIndex(v)
+		if i < 0 || i >= numBuckets {
+			t.Fatalf("bucketIndex(%d) = %d, out of [0, %d)", v, i, numBuckets)
+		}
+		if i < prev {
+			t.Errorf("bucketIndex(%d) = %d, less than for a smaller value (%d)", v, i, prev)
+		}
+		prev = i
+		if err := math.Abs(float64(bucketValue(i)-v)) / math.Max(float64(v), 1); err > 1.0/subCount {
+			t.Errorf("bucketValue(bucketIndex(%d)) = %d, relative error %.3f > %.3f", v, bucketValue(i), err, 1.0/subCount)
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []int64
+		q      float64
+		want   int64
+	}{
+		{"empty", nil, .5, 0},
+		{"single value", []int64{1000}, .5, 1000},
+		{"single value p999", []int64{1000}, .999, 1000},
+		{"midpoint clamped to min", []int64{64, 64}, .5, 64},
+		{"midpoint clamped to max", []int64{66}, 1, 66},
+		{"negative recorded as 0", []int64{-5}, .5, 0},
+		{"1..100 p0 is min", seq(1, 100), 0, 1},
+		{"1..100 p50", seq(1, 100), .5, 50},
+		{"1..100 p90", seq(1, 100), .9, 91}, // 90 is in the [90, 91] bucket
+		{"1..100 p99", seq(1, 100), .99, 99},
+		{"1..100 p999 is max", seq(1, 100), .999, 100},
+		{"outlier", append(seq(1, 999), 1e9), .999, 1000}, // 999 is in the [992, 1007] bucket
+		{"outlier p100", append(seq(1, 999), 1e9), 1, 1e9},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var h histogram
+			for _, v := range tc.values {
+				h.record(v)
+			}
+			if got := h.quantile(tc.q); got != tc.want {
+				t.Errorf("quantile(%v) = %d, want %d", tc.q, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHistogramRecord(t *testing.T) {
+	var h histogram
+	for _, v := range []int64{5, -1, 7, 3} {
+		h.record(v)
+	}
+	if h.count != 4 || h.sum != 15 || h.min != 0 || h.max != 7 {
+		t.Errorf("count, sum, min, max = %d, %d, %d, %d, want 4, 15, 0, 7", h.count, h.sum, h.min, h.max)
+	}
+
+	h.reset()
+	h.record(math.MaxInt64)
+	h.record(math.MaxInt64)
+	if h.sum != math.MaxInt64 {
+		t.Errorf("sum = %d, want it saturated at %d", h.sum, int64(math.MaxInt64))
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		a, b          []int64
+		count         uint64
+		sum, min, max int64
+	}{
+		{"both empty", nil, nil, 0, 0, 0, 0},
+		{"into empty", nil, []int64{4, 8}, 2, 12, 4, 8},
+		{"from empty", []int64{4, 8}, nil, 2, 12, 4, 8},
+		{"lower min", []int64{4, 8}, []int64{2}, 3, 14, 2, 8},
+		{"higher max", []int64{4, 8}, []int64{16}, 3, 28, 4, 16},
+		{"saturated sum", []int64{math.MaxInt64}, []int64{1}, 2, math.MaxInt64, 1, math.MaxInt64},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var a, b histogram
+			for _, v := range tc.a {
+				a.record(v)
+			}
+			for _, v := range tc.b {
+				b.record(v)
+			}
+			a.merge(&b)
+			if a.count != tc.count || a.sum != tc.sum || a.min != tc.min || a.max != tc.max {
+				t.Errorf("count, sum, min, max = %d, %d, %d, %d, want %d, %d, %d, %d", a.count, a.sum, a.min, a.max, tc.count, tc.sum, tc.min, tc.max)
+			}
+			var n uint64
+			for _, c := range a.counts {
+				n += c
+			}
+			if n != tc.count {
+				t.Errorf("bucket counts add up to %d, want %d", n, tc.count)
+			}
+		})
+	}
+}
+
+// seq returns the values from to to, inclusive.
+func seq(from, to int64) []int64 {
+	var vs []int64
+	for v := from; v <= to; v++ {
+		vs = append(vs, v)
+	}
+	return vs
+}
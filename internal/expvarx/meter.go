@@ -0,0 +1,134 @@
+package expvarx
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// meterTick is how often a Meter's moving averages are updated, same as the
+// Unix load averages.
+const meterTick = 5 * time.Second
+
+// ewma is an exponentially weighted moving average of a per second rate.
+type ewma struct {
+	alpha float64
+	rate  float64
+	init  bool
+}
+
+func newEWMA(window time.Duration) ewma {
+	return ewma{alpha: 1 - math.Exp(-meterTick.Seconds()/window.Seconds())}
+}
+
+// update the average with n events that happened over a meterTick.
+func (e *ewma) update(n int64) {
+	r := float64(n) / meterTick.Seconds()
+	if !e.init {
+		e.rate, e.init = r, true
+		return
+	}
+	e.rate += e.alpha * (r - e.rate)
+}
+
+// Meter measures the rate of events as 1, 5 and 15 minute exponentially
+// weighted moving averages, like Unix load averages, and as the mean rate
+// since the Meter was created. All rates are per second. Mark is safe to call
+// from many goroutines at once and doesn't block unless an update of the
+// averages is due.
+//
+// Meter implements expvar.Var.
+type Meter struct {
+	count     int64 // accessed atomically
+	uncounted int64 // events since the last tick, accessed atomically
+	lastTick  int64 // unix nanos, accessed atomically
+
+	start       time.Time
+	mu          sync.Mutex // protects the averages
+	m1, m5, m15 ewma
+}
+
+// NewMeter returns a new Meter.
+func NewMeter() *Meter {
+	now := time.Now()
+	return &Meter{
+		lastTick: now.UnixNano(),
+		start:    now,
+		m1:       newEWMA(time.Minute),
+		m5:       newEWMA(5 * time.Minute),
+		m15:      newEWMA(15 * time.Minute),
+	}
+}
+
+// Mark records n events.
+func (m *Meter) Mark(n int64) {
+	atomic.AddInt64(&m.count, n)
+	atomic.AddInt64(&m.uncounted, n)
+	m.tick(time.Now())
+}
+
+// tick updates the averages for every meterTick that has passed since the
+// last update.
+func (m *Meter) tick(now time.Time) {
+	if now.UnixNano()-atomic.LoadInt64(&m.lastTick) < int64(meterTick) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last := atomic.LoadInt64(&m.lastTick)
+	ticks := (now.UnixNano() - last) / int64(meterTick)
+	if ticks <= 0 { // someone else got here first
+		return
+	}
+	atomic.StoreInt64(&m.lastTick, last+ticks*int64(meterTick))
+
+	// Everything since the last update is attributed to the first tick, the
+	// rest (if any) were idle.
+	n := atomic.SwapInt64(&m.uncounted, 0)
+	for i := int64(0); i < ticks; i++ {
+		m.m1.update(n)
+		m.m5.update(n)
+		m.m15.update(n)
+		n = 0
+	}
+}
+
+// MeterStats are the rates reported by a Meter.
+type MeterStats struct {
+	Count int64
+	M1    float64
+	M5    float64
+	M15   float64
+	Mean  float64
+}
+
+// Stats returns the current rates.
+func (m *Meter) Stats() MeterStats {
+	now := time.Now()
+	m.tick(now)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := MeterStats{
+		Count: atomic.LoadInt64(&m.count),
+		M1:    m.m1.rate,
+		M5:    m.m5.rate,
+		M15:   m.m15.rate,
+	}
+	if d := now.Sub(m.start).Seconds(); d > 0 {
+		s.Mean = float64(s.Count) / d
+	}
+	return s
+}
+
+// String returns the rates as JSON:
+//
+//	{"Count":1234,"M1":2.5,"M5":2.1,"M15":1.9,"Mean":2.2}
+func (m *Meter) String() string {
+	b, err := json.Marshal(m.Stats())
+	if err != nil { // can't happen, it's all numbers
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,129 @@
+package expvarx
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Other replaces route keys once a Routes' limit has been reached, and
+// unknown request methods.
+const Other = "other"
+
+// Routes counts requests, and the time spent handling them, by route, method
+// and status class in nested expvar.Maps. For example:
+//
+//	{"/": {"GET": {"2xx": {"Count": 10, "Sum": 123456789}, "4xx": {...}}}}
+//
+// Sum is in nanoseconds.
+type Routes struct {
+	m         *expvar.Map
+	maxRoutes int
+
+	mu     sync.Mutex // serializes adding new keys
+	routes int
+}
+
+// NewRoutes publishes a Routes as name. At most maxRoutes distinct routes are
+// tracked, requests for any other route are counted under Other.
+func NewRoutes(name string, maxRoutes int) *Routes {
+	return &Routes{m: expvar.NewMap(name), maxRoutes: maxRoutes}
+}
+
+// Handler counts requests handled by next under route. If route is empty the
+// request's path is used instead, which is only bounded by maxRoutes.
+func (rs *Routes) Handler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func(t time.Time) {
+			rt := route
+			if rt == "" {
+				rt = r.URL.Path
+			}
+			rs.observe(rt, r.Method, sw.status, time.Since(t))
+		}(time.Now())
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func (rs *Routes) observe(route, method string, status int, d time.Duration) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+	default:
+		method = Other
+	}
+	if status == 0 { // nothing written, net/http returns 200
+		status = http.StatusOK
+	}
+	class := strconv.Itoa(status/100) + "xx"
+
+	leaf := rs.leaf(rs.route(route), method, class)
+	leaf.Add("Count", 1)
+	leaf.Add("Sum", int64(d))
+}
+
+// route returns the map for route, creating it if there is room.
+func (rs *Routes) route(route string) *expvar.Map {
+	if m, ok := rs.m.Get(route).(*expvar.Map); ok {
+		return m
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if m, ok := rs.m.Get(route).(*expvar.Map); ok { // added in the mean time
+		return m
+	}
+	if rs.routes >= rs.maxRoutes {
+		route = Other
+		if m, ok := rs.m.Get(route).(*expvar.Map); ok {
+			return m
+		}
+	} else {
+		rs.routes++
+	}
+	m := new(expvar.Map).Init()
+	rs.m.Set(route, m)
+	return m
+}
+
+// leaf returns the map holding the Count and Sum for method and class.
+func (rs *Routes) leaf(route *expvar.Map, method, class string) *expvar.Map {
+	methods := rs.child(route, method)
+	return rs.child(methods, class)
+}
+
+func (rs *Routes) child(parent *expvar.Map, key string) *expvar.Map {
+	if m, ok := parent.Get(key).(*expvar.Map); ok {
+		return m
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if m, ok := parent.Get(key).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	parent.Set(key, m)
+	return m
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,134 @@
+// Package expvarx provides expvar.Var implementations for richer metrics than
+// the counters and gauges included in the expvar package.
+package expvarx
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// timerSlots is the number of sub windows a Timer's sliding window is split
+// into. The window slides by window/timerSlots at a time.
+const timerSlots = 6
+
+// Timer records durations and reports their count, sum, average, min, max and
+// 50th, 90th, 99th and 99.9th percentiles, both over the lifetime of the
+// program and over a sliding window. Memory use is fixed, independent of the
+// number of durations recorded. It is safe for concurrent use.
+//
+// Timer implements expvar.Var. All durations are reported in nanoseconds.
+type Timer struct {
+	mu       sync.Mutex
+	lifetime histogram
+	window   [timerSlots]histogram
+	slot     time.Duration // duration of each window slot
+	cur      int           // index of the slot being recorded to
+	curStart time.Time     // when the current slot started
+}
+
+// NewTimer returns a Timer with a sliding window of the given duration.
+func NewTimer(window time.Duration) *Timer {
+	slot := window / timerSlots
+	if slot <= 0 {
+		slot = 1
+	}
+	return &Timer{slot: slot, curStart: time.Now()}
+}
+
+// advance rotates the window slots up to now. t.mu must be held.
+func (t *Timer) advance(now time.Time) {
+	n := int(now.Sub(t.curStart) / t.slot)
+	if n <= 0 {
+		return
+	}
+	for i := 0; i < n && i < timerSlots; i++ {
+		t.cur = (t.cur + 1) % timerSlots
+		t.window[t.cur].reset()
+	}
+	t.curStart = t.curStart.Add(time.Duration(n) * t.slot)
+}
+
+// Observe records d.
+func (t *Timer) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.advance(time.Now())
+	t.lifetime.record(int64(d))
+	t.window[t.cur].record(int64(d))
+}
+
+// Finish records the time since start.
+//
+//	defer t.Finish(time.Now())
+func (t *Timer) Finish(start time.Time) {
+	t.Observe(time.Since(start))
+}
+
+// TimerStats are the statistics reported for a set of durations.
+type TimerStats struct {
+	Count uint64
+	Sum   int64
+	Avg   int64
+	Min   int64
+	Max   int64
+	P50   int64
+	P90   int64
+	P99   int64
+	P999  int64
+}
+
+func newTimerStats(h *histogram) TimerStats {
+	s := TimerStats{
+		Count: h.count,
+		Sum:   h.sum,
+		Min:   h.min,
+		Max:   h.max,
+		P50:   h.quantile(.5),
+		P90:   h.quantile(.9),
+		P99:   h.quantile(.99),
+		P999:  h.quantile(.999),
+	}
+	if h.count != 0 { // avoid divide by zero
+		s.Avg = h.sum / int64(h.count)
+	}
+	return s
+}
+
+type timerJSON struct {
+	TimerStats
+	Window windowJSON
+}
+
+type windowJSON struct {
+	Duration time.Duration
+	TimerStats
+}
+
+// Stats returns the lifetime and sliding window statistics.
+func (t *Timer) Stats() (lifetime, window TimerStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.advance(time.Now())
+	var w histogram
+	for i := range t.window {
+		w.merge(&t.window[i])
+	}
+	return newTimerStats(&t.lifetime), newTimerStats(&w)
+}
+
+// String returns the lifetime statistics, with the window statistics nested
+// under "Window", as JSON:
+//
+//	{"Count":10,"Sum":500,"Avg":50,...,"P999":98,"Window":{"Duration":60000000000,"Count":2,...}}
+func (t *Timer) String() string {
+	l, w := t.Stats()
+	b, err := json.Marshal(timerJSON{
+		TimerStats: l,
+		Window:     windowJSON{Duration: t.slot * timerSlots, TimerStats: w},
+	})
+	if err != nil { // can't happen, it's all numbers
+		return "{}"
+	}
+	return string(b)
+}
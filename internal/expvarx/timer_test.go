@@ -0,0 +1,84 @@
+package expvarx
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimerWindow(t *testing.T) {
+	const window = time.Minute
+	for _, tc := range []struct {
+		name    string
+		elapsed []time.Duration // time passed before each Observe, and before Stats
+		window  uint64          // expected window count
+	}{
+		{"same slot", []time.Duration{0, 0, 0}, 2},
+		{"next slot", []time.Duration{0, window / timerSlots, 0}, 2},
+		{"last slot still in the window", []time.Duration{0, 0, window - window/timerSlots}, 2},
+		{"slid out", []time.Duration{0, 0, window}, 0},
+		{"half slid out", []time.Duration{0, window / 2, window / 2}, 1},
+		{"long idle", []time.Duration{0, 0, 100 * window}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tm := NewTimer(window)
+			for i, d := range tc.elapsed {
+				tm.curStart = tm.curStart.Add(-d) // pretend d passed
+				if i < len(tc.elapsed)-1 {
+					tm.Observe(time.Millisecond)
+				}
+			}
+			lifetime, w := tm.Stats()
+			if lifetime.Count != uint64(len(tc.elapsed)-1) {
+				t.Errorf("lifetime count = %d, want %d", lifetime.Count, len(tc.elapsed)-1)
+			}
+			if w.Count != tc.window {
+				t.Errorf("window count = %d, want %d", w.Count, tc.window)
+			}
+		})
+	}
+}
+
+func TestTimerStats(t *testing.T) {
+	tm := NewTimer(time.Minute)
+	if l, w := tm.Stats(); l != (TimerStats{}) || w != (TimerStats{}) {
+		t.Errorf("Stats() of a new Timer = %+v, %+v, want zero values", l, w)
+	}
+
+	for _, d := range []time.Duration{10, 20, 30, 40} {
+		tm.Observe(d)
+	}
+	l, _ := tm.Stats()
+	want := TimerStats{Count: 4, Sum: 100, Avg: 25, Min: 10, Max: 40, P50: 20, P90: 40, P99: 40, P999: 40}
+	if l != want {
+		t.Errorf("lifetime stats = %+v, want %+v", l, want)
+	}
+}
+
+func TestTimerString(t *testing.T) {
+	tm := NewTimer(time.Minute)
+	tm.Observe(time.Second)
+
+	var got struct {
+		Count  uint64
+		Max    int64
+		Window struct {
+			Duration time.Duration
+			Count    uint64
+		}
+	}
+	if err := json.Unmarshal([]byte(tm.String()), &got); err != nil {
+		t.Fatalf("String() isn't JSON: %v", err)
+	}
+	if got.Count != 1 || got.Max != int64(time.Second) || got.Window.Duration != time.Minute || got.Window.Count != 1 {
+		t.Errorf("String() = %s", tm.String())
+	}
+}
+
+func TestNewTimerTinyWindow(t *testing.T) {
+	tm := NewTimer(0) // slots can't be 0 long
+	tm.Observe(time.Millisecond)
+	if l, _ := tm.Stats(); l.Count != 1 {
+		t.Errorf("lifetime count = %d, want 1", l.Count)
+	}
+}
@@ -0,0 +1,120 @@
+// Package levellog adds log levels to the standard library's log.Logger.
+package levellog
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a log line.
+type Level int32
+
+// The supported levels, from most to least verbose.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (lvl Level) String() string {
+	switch lvl {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int32(lvl))
+}
+
+// ParseLevel converts a level name (debug, info, warn or error, in any case)
+// to a Level.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return Debug, nil
+	case "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	}
+	return Info, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes lines at or above a minimum level to a *log.Logger, prefixing
+// the message with the line's level. The wrapped logger's flags, including
+// Lshortfile/Llongfile, are honored and report the caller of Logger's methods.
+type Logger struct {
+	l   *log.Logger
+	min int32 // Level, accessed atomically
+}
+
+// New returns a Logger that writes lines at or above min to l.
+func New(l *log.Logger, min Level) *Logger {
+	return &Logger{l: l, min: int32(min)}
+}
+
+// SetLevel changes the minimum level. It is safe to call concurrently with
+// logging.
+func (l *Logger) SetLevel(lvl Level) {
+	atomic.StoreInt32(&l.min, int32(lvl))
+}
+
+// Level returns the current minimum level.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.min))
+}
+
+// Enabled reports whether lines at lvl will be written.
+func (l *Logger) Enabled(lvl Level) bool {
+	return lvl >= l.Level()
+}
+
+// output is only called directly by the exported methods so the calldepth
+// passed to log.Logger.Output is always the same.
+func (l *Logger) output(lvl Level, s string) {
+	if !l.Enabled(lvl) {
+		return
+	}
+	l.l.Output(3, lvl.String()+" "+s)
+}
+
+// Debug logs at Debug level. Arguments are handled in the manner of fmt.Println.
+func (l *Logger) Debug(v ...interface{}) { l.output(Debug, fmt.Sprintln(v...)) }
+
+// Debugf logs at Debug level. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Debugf(format string, v ...interface{}) { l.output(Debug, fmt.Sprintf(format, v...)) }
+
+// Info logs at Info level. Arguments are handled in the manner of fmt.Println.
+func (l *Logger) Info(v ...interface{}) { l.output(Info, fmt.Sprintln(v...)) }
+
+// Infof logs at Info level. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Infof(format string, v ...interface{}) { l.output(Info, fmt.Sprintf(format, v...)) }
+
+// Warn logs at Warn level. Arguments are handled in the manner of fmt.Println.
+func (l *Logger) Warn(v ...interface{}) { l.output(Warn, fmt.Sprintln(v...)) }
+
+// Warnf logs at Warn level. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Warnf(format string, v ...interface{}) { l.output(Warn, fmt.Sprintf(format, v...)) }
+
+// Error logs at Error level. Arguments are handled in the manner of fmt.Println.
+func (l *Logger) Error(v ...interface{}) { l.output(Error, fmt.Sprintln(v...)) }
+
+// Errorf logs at Error level. Arguments are handled in the manner of fmt.Printf.
+func (l *Logger) Errorf(format string, v ...interface{}) { l.output(Error, fmt.Sprintf(format, v...)) }
+
+// Fatal logs at Error level, regardless of the minimum level, and then calls
+// os.Exit(1).
+func (l *Logger) Fatal(v ...interface{}) {
+	l.l.Output(2, Error.String()+" "+fmt.Sprintln(v...))
+	os.Exit(1)
+}
@@ -0,0 +1,70 @@
+// Package logging carries request scoped logrus loggers in a context.Context.
+package logging
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/sirupsen/logrus"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx that carries log.
+func NewContext(ctx context.Context, log logrus.FieldLogger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, log)
+}
+
+// FromContext returns the logger carried by ctx. If ctx doesn't carry one the
+// standard logrus logger is returned, so the result is always usable. When
+// possible ctx is attached to the returned logger, so formatters and hooks
+// (like TraceFormatter) can use it.
+func FromContext(ctx context.Context) logrus.FieldLogger {
+	log, ok := ctx.Value(ctxKey{}).(logrus.FieldLogger)
+	if !ok {
+		log = logrus.StandardLogger()
+	}
+	switch l := log.(type) {
+	case *logrus.Entry:
+		return l.WithContext(ctx)
+	case *logrus.Logger:
+		return l.WithContext(ctx)
+	}
+	return log
+}
+
+// Middleware derives a logger from log for each request, curried with the
+// request's method and path, and attaches it to the request's context. Nothing
+// is shared between requests except log itself, which is never modified.
+func Middleware(log logrus.FieldLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := log.WithFields(logrus.Fields{
+			"method": r.Method,
+			"path":   r.URL.String(),
+		})
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), l)))
+	})
+}
+
+// Access is Middleware that also writes an access line, with the response's
+// status and the request's duration, once the request completes.
+func Access(log logrus.FieldLogger, next http.Handler) http.Handler {
+	return Middleware(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func(t time.Time) {
+			status := sw.status
+			if status == 0 { // nothing written, net/http returns 200
+				status = http.StatusOK
+			}
+			FromContext(r.Context()).WithFields(logrus.Fields{
+				loglevel.ComponentKey: "access",
+				"status":              status,
+				"duration":            time.Since(t).Seconds(),
+			}).Info()
+		}(time.Now())
+
+		next.ServeHTTP(sw, r)
+	}))
+}
@@ -0,0 +1,217 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/sirupsen/logrus"
+)
+
+// Default per request limits used by Tail when TailOptions doesn't set them.
+const (
+	DefaultTailMaxEntries = 100
+	DefaultTailMaxBytes   = 64 << 10
+)
+
+// TailOptions configures Tail.
+type TailOptions struct {
+	// Latency over which a request's buffered lines are emitted. Zero
+	// disables the latency check.
+	Latency time.Duration
+
+	// MaxEntries and MaxBytes cap how many lines, and how many formatted
+	// bytes, are buffered for a single request. Lines past either limit are
+	// dropped, except for warnings and errors, which are written immediately.
+	MaxEntries int
+	MaxBytes   int
+}
+
+// Tail is like Middleware, except that debug and info lines logged via the
+// request's logger are held in memory until the request completes. They are
+// only written when the request is interesting: it responded with a status >=
+// 400, took longer than opts.Latency, panicked, or logged a warning or error.
+// Otherwise only a single access line is written for the request.
+//
+// The output of log's Logger is replaced by one serializing writes, so that the
+// buffered lines, written at once, don't interleave with concurrent lines.
+func Tail(log *logrus.Entry, opts TailOptions, next http.Handler) http.Handler {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultTailMaxEntries
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultTailMaxBytes
+	}
+	out := lockOutput(log.Logger)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		fields := logrus.Fields{
+			"method": r.Method,
+			"path":   r.URL.String(),
+		}
+		buf := &tailBuffer{maxEntries: opts.MaxEntries, maxBytes: opts.MaxBytes}
+		l := logrus.NewEntry(newTailLogger(log.Logger, out, buf)).WithFields(log.Data).WithFields(fields)
+		sw := &statusWriter{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+			status := sw.status
+			switch {
+			case p != nil:
+				status = http.StatusInternalServerError
+			case status == 0: // nothing written, net/http returns 200
+				status = http.StatusOK
+			}
+			d := time.Since(start)
+
+			access := log.WithFields(fields).WithFields(logrus.Fields{
+				loglevel.ComponentKey: "access",
+				"status":              status,
+				"duration":            d.Seconds(),
+			})
+			if p != nil || status >= 400 || (opts.Latency > 0 && d > opts.Latency) || buf.failed() {
+				if n := buf.flush(out); n > 0 {
+					access = access.WithField("dropped_log_entries", n)
+				}
+			}
+			if p != nil {
+				access.WithField("panic", fmt.Sprint(p)).Error()
+				panic(p)
+			}
+			access.Info()
+		}()
+
+		next.ServeHTTP(sw, r.WithContext(NewContext(r.Context(), l)))
+	})
+}
+
+// lockedWriter serializes the writes to w. Each write is a whole line, or all
+// of a request's buffered lines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(b []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(b)
+}
+
+var lockOutputMu sync.Mutex
+
+// lockOutput wraps l's output in a lockedWriter, unless it already is one, and
+// returns it. The logger's own mutex can't be used as it's unexported, but all
+// the writes to its output go through the lockedWriter.
+func lockOutput(l *logrus.Logger) *lockedWriter {
+	lockOutputMu.Lock()
+	defer lockOutputMu.Unlock()
+	if lw, ok := l.Out.(*lockedWriter); ok {
+		return lw
+	}
+	lw := &lockedWriter{w: l.Out}
+	l.SetOutput(lw)
+	return lw
+}
+
+// newTailLogger returns a logger that looks like base, writing to out, but
+// buffers formatted lines in buf instead of writing them.
+func newTailLogger(base *logrus.Logger, out io.Writer, buf *tailBuffer) *logrus.Logger {
+	return &logrus.Logger{
+		Out:          out,
+		Hooks:        base.Hooks,
+		Formatter:    &tailFormatter{Formatter: base.Formatter, buf: buf},
+		ReportCaller: base.ReportCaller,
+		Level:        base.GetLevel(),
+		ExitFunc:     base.ExitFunc,
+	}
+}
+
+type tailFormatter struct {
+	logrus.Formatter
+	buf *tailBuffer
+}
+
+// Format returns nothing to write for lines that were buffered or dropped.
+func (f *tailFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	b, err := f.Formatter.Format(e)
+	if err != nil || len(b) == 0 {
+		return b, err
+	}
+	if f.buf.add(b, e.Level <= logrus.WarnLevel) {
+		return nil, nil
+	}
+	if e.Level <= logrus.WarnLevel {
+		return b, nil
+	}
+	return nil, nil
+}
+
+type tailBuffer struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	entries    int
+	dropped    int
+	fail       bool
+	b          bytes.Buffer
+}
+
+// add copies line into the buffer, reporting whether there was room for it.
+// Lines that didn't fit, and aren't important, are counted as dropped.
+func (t *tailBuffer) add(line []byte, important bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if important {
+		t.fail = true
+	}
+	if t.entries >= t.maxEntries || t.b.Len()+len(line) > t.maxBytes {
+		if !important {
+			t.dropped++
+		}
+		return false
+	}
+	t.entries++
+	t.b.Write(line) // copies, line may be a pooled logrus buffer
+	return true
+}
+
+func (t *tailBuffer) failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fail
+}
+
+// flush writes the buffered lines to w and returns the number of lines that
+// were dropped.
+func (t *tailBuffer) flush(w io.Writer) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w.Write(t.b.Bytes())
+	t.b.Reset()
+	t.entries = 0
+	return t.dropped
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+// TraceFormatter adds the trace_id, span_id and sampled fields of the
+// OpenCensus span carried by a line's context (see FromContext and
+// logrus.Entry.WithContext) before formatting the line with Formatter. This
+// allows log lines to be joined with the trace they were emitted during.
+type TraceFormatter struct {
+	logrus.Formatter
+}
+
+// Format the entry, including the span's fields if it has one.
+func (f *TraceFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	if e.Context == nil {
+		return f.Formatter.Format(e)
+	}
+	span := trace.FromContext(e.Context)
+	if span == nil {
+		return f.Formatter.Format(e)
+	}
+	sc := span.SpanContext()
+
+	// e.Data may be shared with other entries, so add the fields to a copy.
+	data := make(logrus.Fields, len(e.Data)+3)
+	for k, v := range e.Data {
+		data[k] = v
+	}
+	data["trace_id"] = sc.TraceID.String()
+	data["span_id"] = sc.SpanID.String()
+	data["sampled"] = sc.IsSampled()
+
+	c := *e
+	c.Data = data
+	return f.Formatter.Format(&c)
+}
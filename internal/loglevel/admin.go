@@ -0,0 +1,41 @@
+package loglevel
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// EnvAdminAddr is the environment variable holding the address ServeAdmin
+// listens on.
+const EnvAdminAddr = "ADMIN_ADDR"
+
+// ServeAdmin serves l at /debug/loglevel on the address in the ADMIN_ADDR
+// environment variable, or on def when it's unset, so that it stays off the
+// program's public port. An empty address disables it.
+//
+// It returns once listening, so that a busy address is an error, and serves in
+// the background. The address listened on is returned, empty when disabled.
+func (l *Levels) ServeAdmin(def string) (string, error) {
+	addr, ok := os.LookupEnv(EnvAdminAddr)
+	if !ok {
+		addr = def
+	}
+	if addr == "" {
+		return "", nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("loglevel: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/loglevel", l)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			l.log.WithError(err).Error("loglevel: serving the admin address")
+		}
+	}()
+	return ln.Addr().String(), nil
+}
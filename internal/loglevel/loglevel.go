@@ -0,0 +1,253 @@
+// Package loglevel allows the level of a logrus.Logger to be changed while the
+// program is running, either for all log lines or per component, and
+// optionally only for a limited time.
+package loglevel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentKey is the field used to tag log lines with the component that
+// emitted them. Lines without it, or with a component that has no override,
+// use the default level.
+//
+//	log.WithField(loglevel.ComponentKey, "work").Debug("...")
+const ComponentKey = "component"
+
+type setting struct {
+	level   logrus.Level
+	expires time.Time // zero if the setting never expires
+	timer   *time.Timer
+}
+
+func (s *setting) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// Levels controls the level of a logrus.Logger.
+type Levels struct {
+	log *logrus.Logger
+
+	mu         sync.RWMutex
+	base       logrus.Level // default level to revert to when a temporary default expires
+	def        setting
+	components map[string]*setting
+}
+
+// New takes control of log's level. It wraps log's Formatter to drop lines
+// from components logging below their level, so it must be called after the
+// formatter has been set.
+func New(log *logrus.Logger) *Levels {
+	l := &Levels{
+		log:        log,
+		base:       log.GetLevel(),
+		def:        setting{level: log.GetLevel()},
+		components: make(map[string]*setting),
+	}
+	log.Formatter = &filter{Formatter: log.Formatter, levels: l}
+	return l
+}
+
+// Set the default level. If ttl is > 0 the level reverts to the previous
+// permanent level after ttl.
+func (l *Levels) Set(lvl logrus.Level, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def.stop()
+	l.def = setting{level: lvl}
+	if ttl <= 0 {
+		l.base = lvl
+	} else {
+		l.def.expires = time.Now().Add(ttl)
+		expires := l.def.expires
+		l.def.timer = time.AfterFunc(ttl, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.def.expires.Equal(expires) { // not replaced in the mean time
+				l.def = setting{level: l.base}
+				l.apply()
+			}
+		})
+	}
+	l.apply()
+}
+
+// SetComponent overrides the level of lines logged by component. If ttl is > 0
+// the override is removed after ttl.
+func (l *Levels) SetComponent(component string, lvl logrus.Level, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.components[component]; ok {
+		s.stop()
+	}
+	s := &setting{level: lvl}
+	if ttl > 0 {
+		s.expires = time.Now().Add(ttl)
+		s.timer = time.AfterFunc(ttl, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.components[component] == s {
+				delete(l.components, component)
+				l.apply()
+			}
+		})
+	}
+	l.components[component] = s
+	l.apply()
+}
+
+// ClearComponent removes any override for component.
+func (l *Levels) ClearComponent(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.components[component]; ok {
+		s.stop()
+		delete(l.components, component)
+	}
+	l.apply()
+}
+
+// apply sets the logger's level to the most verbose level in use, so that
+// logrus doesn't discard lines before the filter gets to see them. l.mu must
+// be held.
+func (l *Levels) apply() {
+	lvl := l.def.level
+	for _, s := range l.components {
+		if s.level > lvl {
+			lvl = s.level
+		}
+	}
+	l.log.SetLevel(lvl)
+}
+
+func (l *Levels) enabled(e *logrus.Entry) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	lvl := l.def.level
+	if c, ok := e.Data[ComponentKey].(string); ok {
+		if s, ok := l.components[c]; ok {
+			lvl = s.level
+		}
+	}
+	return e.Level <= lvl
+}
+
+type filter struct {
+	logrus.Formatter
+	levels *Levels
+}
+
+func (f *filter) Format(e *logrus.Entry) ([]byte, error) {
+	if !f.levels.enabled(e) {
+		return nil, nil
+	}
+	return f.Formatter.Format(e)
+}
+
+type levelState struct {
+	Level   string     `json:"level"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type state struct {
+	levelState
+	Components map[string]levelState `json:"components"`
+}
+
+func newLevelState(s *setting) levelState {
+	ls := levelState{Level: s.level.String()}
+	if !s.expires.IsZero() {
+		e := s.expires
+		ls.Expires = &e
+	}
+	return ls
+}
+
+func (l *Levels) state() state {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	st := state{
+		levelState: newLevelState(&l.def),
+		Components: make(map[string]levelState, len(l.components)),
+	}
+	for c, s := range l.components {
+		st.Components[c] = newLevelState(s)
+	}
+	return st
+}
+
+// ServeHTTP reports the current levels on GET and changes them on PUT. PUT
+// takes the following query or form parameters:
+//
+//	level      required, one of the logrus levels (debug, info, ...), or empty
+//	           to remove a component's override
+//	component  optional, only change the level of this component
+//	ttl        optional, a time.Duration after which the change is undone
+//
+// Example:
+//
+//	curl -X PUT 'localhost:9080/debug/loglevel?level=debug&component=work&ttl=5m'
+//
+// It doesn't authenticate requests, so mount it on an address only operators
+// can reach rather than on a public port, e.g. with ServeAdmin.
+func (l *Levels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		if err := l.update(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(l.state())
+}
+
+func (l *Levels) update(r *http.Request) error {
+	var ttl time.Duration
+	if v := r.FormValue("ttl"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid ttl: %v", err)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("invalid ttl: %s is negative", v)
+		}
+	}
+
+	component := r.FormValue("component")
+	v := r.FormValue("level")
+	if v == "" {
+		if component == "" {
+			return fmt.Errorf("level is required")
+		}
+		l.ClearComponent(component)
+		return nil
+	}
+	lvl, err := logrus.ParseLevel(v)
+	if err != nil {
+		return err
+	}
+
+	if component == "" {
+		l.Set(lvl, ttl)
+	} else {
+		l.SetComponent(component, lvl, ttl)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package promclient instruments outbound HTTP requests with Prometheus
+// metrics, building on promhttp's InstrumentRoundTripper middlewares.
+package promclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are the collectors used by Transport. They are labelled by the
+// target service and, except for InFlight and Trace, the route of the
+// request. Metrics is a prometheus.Collector, register it before use.
+type Metrics struct {
+	// InFlight is the number of requests currently being made.
+	InFlight *prometheus.GaugeVec
+	// Requests counts requests that received a response, by status code.
+	Requests *prometheus.CounterVec
+	// Durations observes the time until the response headers were read, by
+	// status code.
+	Durations *prometheus.HistogramVec
+	// Trace observes the time from the start of the request until DNS
+	// resolution finished ("dns"), the connection was established
+	// ("connect") and the first response byte was read ("ttfb"). "dns" and
+	// "connect" are only observed when a new connection is made.
+	Trace *prometheus.HistogramVec
+	// Errors counts requests that didn't receive a response, split by reason:
+	// "timeout" or "error".
+	Errors *prometheus.CounterVec
+}
+
+// NewMetrics returns Metrics with the standard http_client_* names. buckets
+// are used for the Durations and Trace histograms, prometheus.DefBuckets if
+// nil.
+func NewMetrics(buckets []float64) *Metrics {
+	return &Metrics{
+		InFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_client_in_flight_requests",
+			Help: "HTTP client requests currently in flight.",
+		}, []string{"service"}),
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "HTTP client requests that received a response.",
+		}, []string{"service", "route", "code"}),
+		Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "HTTP client request duration, until the response headers were read.",
+			Buckets: buckets,
+		}, []string{"service", "route", "code"}),
+		Trace: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_trace_seconds",
+			Help:    "Time from the start of HTTP client requests until an event (dns, connect, ttfb).",
+			Buckets: buckets,
+		}, []string{"service", "event"}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_errors_total",
+			Help: "HTTP client requests that didn't receive a response, by reason (timeout, error).",
+		}, []string{"service", "route", "reason"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.InFlight, m.Requests, m.Durations, m.Trace, m.Errors}
+}
+
+// Transport is a http.RoundTripper that records Metrics for the requests made
+// through Base.
+type Transport struct {
+	// Base is the RoundTripper used to make the requests. If nil
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Service is the value of the service label, the service being called.
+	Service string
+
+	// Route returns the value of the route label for a request. If nil the
+	// request's URL path is used, which is only suitable when a handful of
+	// paths are called.
+	Route func(*http.Request) string
+
+	Metrics *Metrics
+
+	routes sync.Map // route -> http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var route string
+	switch {
+	case t.Route != nil:
+		route = t.Route(r)
+	case r.URL.Path == "":
+		route = "/"
+	default:
+		route = r.URL.Path
+	}
+
+	rt, ok := t.routes.Load(route)
+	if !ok {
+		rt, _ = t.routes.LoadOrStore(route, t.instrument(route))
+	}
+
+	resp, err := rt.(http.RoundTripper).RoundTrip(r)
+	if err != nil {
+		reason := "error"
+		if timeout(r.Context(), err) {
+			reason = "timeout"
+		}
+		t.Metrics.Errors.WithLabelValues(t.Service, route, reason).Inc()
+	}
+	return resp, err
+}
+
+// instrument returns the instrumented RoundTripper for route. The promhttp
+// middlewares only allow the "code" and "method" labels to vary, so the
+// others are curried once per route.
+func (t *Transport) instrument(route string) http.RoundTripper {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	l := prometheus.Labels{"service": t.Service, "route": route}
+	trace := t.Metrics.Trace.MustCurryWith(prometheus.Labels{"service": t.Service})
+	it := &promhttp.InstrumentTrace{
+		DNSDone:              trace.WithLabelValues("dns").Observe,
+		ConnectDone:          trace.WithLabelValues("connect").Observe,
+		GotFirstResponseByte: trace.WithLabelValues("ttfb").Observe,
+	}
+
+	return promhttp.InstrumentRoundTripperInFlight(t.Metrics.InFlight.WithLabelValues(t.Service),
+		promhttp.InstrumentRoundTripperCounter(t.Metrics.Requests.MustCurryWith(l),
+			promhttp.InstrumentRoundTripperTrace(it,
+				promhttp.InstrumentRoundTripperDuration(t.Metrics.Durations.MustCurryWith(l), base),
+			),
+		),
+	)
+}
+
+// timeout reports whether err was caused by a timeout: either a net.Error
+// that says so, or the request's context deadline having passed, which is
+// also how http.Client enforces its Timeout.
+func timeout(ctx context.Context, err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return ctx.Err() == context.DeadlineExceeded
+}
@@ -0,0 +1,133 @@
+// Package promroute registers HTTP routes while pre-initializing the
+// Prometheus series that describe them.
+//
+// A labelled series doesn't exist until it has been observed at least once, so
+// a handler that has never returned a 400 has no 400 series, and queries like
+// rate(...{code="400"}[5m]) return nothing instead of 0. Hand writing the
+// WithLabelValues calls for every handler/code pair works, until the handler
+// labels used to curry the vecs drift away from the ones that were
+// initialized. A Table keeps the two together.
+package promroute
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultLabel is the label used for the handler name when Table.Label is
+// empty.
+const DefaultLabel = "handler"
+
+// Table registers routes on Mux. For every route it initializes the series of
+// each of Vecs for the route's handler label and expected status codes.
+type Table struct {
+	// Mux the routes are registered on. If nil http.DefaultServeMux is used.
+	Mux interface {
+		Handle(pattern string, handler http.Handler)
+	}
+
+	// Label is the name of the handler label, DefaultLabel if empty.
+	Label string
+
+	// Codes are the status codes every route is expected to return.
+	Codes []int
+
+	// Vecs are initialized for each route. They must have exactly two
+	// variable labels: Label and "code".
+	Vecs []prometheus.ObserverVec
+
+	mu       sync.Mutex
+	handlers map[string]bool
+	curried  []curried
+}
+
+type curried struct {
+	vec     prometheus.ObserverVec
+	handler string
+}
+
+func (t *Table) label() string {
+	if t.Label == "" {
+		return DefaultLabel
+	}
+	return t.Label
+}
+
+// Handle registers h for pattern on t.Mux, initializing the series of t.Vecs
+// for handler and t.Codes plus any extra codes. It panics if a series can't be
+// created, like prometheus.MustRegister, as that's a programming error.
+func (t *Table) Handle(pattern, handler string, h http.Handler, codes ...int) {
+	t.mu.Lock()
+	if t.handlers == nil {
+		t.handlers = make(map[string]bool)
+	}
+	t.handlers[handler] = true
+	t.mu.Unlock()
+
+	for _, vec := range t.Vecs {
+		for _, c := range append(append([]int(nil), t.Codes...), codes...) {
+			vec.With(prometheus.Labels{t.label(): handler, "code": strconv.Itoa(c)})
+		}
+	}
+
+	mux := t.Mux
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle(pattern, h)
+}
+
+// HandleFunc is like Handle, but for a handler function.
+func (t *Table) HandleFunc(pattern, handler string, h func(http.ResponseWriter, *http.Request), codes ...int) {
+	t.Handle(pattern, handler, http.HandlerFunc(h), codes...)
+}
+
+// Curry returns vec curried with handler. Use it instead of MustCurryWith so
+// that Check can verify the handler's series were initialized.
+func (t *Table) Curry(vec prometheus.ObserverVec, handler string) prometheus.ObserverVec {
+	t.mu.Lock()
+	t.curried = append(t.curried, curried{vec: vec, handler: handler})
+	t.mu.Unlock()
+	return vec.MustCurryWith(prometheus.Labels{t.label(): handler})
+}
+
+// Check returns an error if a vec was curried with a handler label whose
+// series were never initialized, either because no route was registered for
+// the handler or because the vec isn't one of t.Vecs. Call it once all routes
+// are registered and refuse to start if it fails.
+func (t *Table) Check() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	missing := make(map[string]bool)
+	for _, c := range t.curried {
+		if !t.handlers[c.handler] || !t.hasVec(c.vec) {
+			missing[c.handler] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(missing))
+	for n := range missing {
+		names = append(names, strconv.Quote(n))
+	}
+	sort.Strings(names)
+	return fmt.Errorf("promroute: %s label(s) curried but never initialized: %s", t.label(), strings.Join(names, ", "))
+}
+
+func (t *Table) hasVec(vec prometheus.ObserverVec) bool {
+	for _, v := range t.Vecs {
+		if v == vec {
+			return true
+		}
+	}
+	return false
+}
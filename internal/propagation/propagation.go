@@ -0,0 +1,174 @@
+// Package propagation combines HTTP trace propagation formats, so that a
+// service can join traces started by callers speaking W3C Trace Context, B3
+// or B3 single header, while choosing which of them it sends downstream.
+package propagation
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/trace"
+	ocprop "go.opencensus.io/trace/propagation"
+)
+
+// Names of the supported formats.
+const (
+	TraceContext = "tracecontext" // W3C Trace Context: traceparent & tracestate headers
+	B3           = "b3"           // B3 multi header: X-B3-TraceId, X-B3-SpanId, ...
+	B3Single     = "b3single"     // B3 single header: b3
+)
+
+// DefaultInject are the formats injected by default.
+const DefaultInject = TraceContext + "," + B3
+
+// EnvInject is the environment variable read by RegisterFlag to override the
+// default of the -trace-propagation flag.
+const EnvInject = "TRACE_PROPAGATION"
+
+// RegisterFlag registers the -trace-propagation flag, the comma separated list
+// of formats to inject, on fs. It defaults to the value of EnvInject, or to
+// DefaultInject if it's unset.
+func RegisterFlag(fs *flag.FlagSet) *string {
+	def := DefaultInject
+	if v, ok := os.LookupEnv(EnvInject); ok {
+		def = v
+	}
+	return fs.String("trace-propagation", def, "comma separated trace propagation `formats` to inject: tracecontext, b3 and/or b3single")
+}
+
+// Format is a composite ocprop.HTTPFormat, usable as ochttp.Handler's and
+// ochttp.Transport's Propagation.
+type Format struct {
+	// Extract are tried in order, the first one finding a span context in
+	// the request wins.
+	Extract []ocprop.HTTPFormat
+
+	// Inject are all applied to outgoing requests.
+	Inject []ocprop.HTTPFormat
+}
+
+var _ ocprop.HTTPFormat = (*Format)(nil)
+
+// Extractors returns the formats extracted by a Format made by New, in order
+// of preference: Trace Context, then B3 single header, then B3.
+func Extractors() []ocprop.HTTPFormat {
+	return []ocprop.HTTPFormat{&tracecontext.HTTPFormat{}, &B3SingleFormat{}, &b3.HTTPFormat{}}
+}
+
+// New Format extracting any of the supported formats (see Extractors), and
+// injecting the comma separated list of formats in inject (e.g.
+// DefaultInject).
+func New(inject string) (*Format, error) {
+	f := &Format{
+		Extract: Extractors(),
+	}
+	for _, name := range strings.Split(inject, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		hf, err := ByName(name)
+		if err != nil {
+			return nil, err
+		}
+		f.Inject = append(f.Inject, hf)
+	}
+	return f, nil
+}
+
+// ByName returns the format named name.
+func ByName(name string) (ocprop.HTTPFormat, error) {
+	switch name {
+	case TraceContext:
+		return &tracecontext.HTTPFormat{}, nil
+	case B3:
+		return &b3.HTTPFormat{}, nil
+	case B3Single:
+		return &B3SingleFormat{}, nil
+	default:
+		return nil, fmt.Errorf("propagation: unknown format %q", name)
+	}
+}
+
+// SpanContextFromRequest implements ocprop.HTTPFormat.
+func (f *Format) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	for _, hf := range f.Extract {
+		if sc, ok := hf.SpanContextFromRequest(req); ok {
+			return sc, true
+		}
+	}
+	return trace.SpanContext{}, false
+}
+
+// SpanContextToRequest implements ocprop.HTTPFormat.
+func (f *Format) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	for _, hf := range f.Inject {
+		hf.SpanContextToRequest(sc, req)
+	}
+}
+
+// B3SingleHeader is the header used by B3SingleFormat.
+const B3SingleHeader = "b3"
+
+// B3SingleFormat implements the B3 single header format:
+//
+//	b3: {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}
+//
+// where SamplingState ("1", "0" or "d" for debug) and ParentSpanId are
+// optional. See https://github.com/openzipkin/b3-propagation#single-header.
+type B3SingleFormat struct{}
+
+// SpanContextFromRequest implements ocprop.HTTPFormat. A header carrying only
+// a sampling state (e.g. "b3: 0") has no span context.
+func (f *B3SingleFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	parts := strings.Split(req.Header.Get(B3SingleHeader), "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return trace.SpanContext{}, false
+	}
+	tid, ok := b3.ParseTraceID(parts[0])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	sid, ok := b3.ParseSpanID(parts[1])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	var opts trace.TraceOptions
+	if len(parts) > 2 {
+		s, ok := ParseB3SamplingState(parts[2])
+		if !ok {
+			return trace.SpanContext{}, false
+		}
+		if s {
+			opts = 1
+		}
+	}
+	return trace.SpanContext{TraceID: tid, SpanID: sid, TraceOptions: opts}, true
+}
+
+// SpanContextToRequest implements ocprop.HTTPFormat.
+func (f *B3SingleFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	req.Header.Set(B3SingleHeader, fmt.Sprintf("%x-%x-%s", sc.TraceID[:], sc.SpanID[:], sampled))
+}
+
+// ParseB3SamplingState parses the sampling state of a B3 single header. Debug
+// ("d") is sampled.
+func ParseB3SamplingState(s string) (sampled, ok bool) {
+	switch s {
+	case "1", "d":
+		return true, true
+	case "0":
+		return false, true
+	default:
+		return false, false
+	}
+}
@@ -0,0 +1,122 @@
+package propagation
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"go.opencensus.io/trace"
+)
+
+var (
+	traceID = trace.TraceID{0x80, 0xf1, 0x98, 0xee, 0x56, 0x34, 0x3b, 0xa8, 0x64, 0xfe, 0x8b, 0x2a, 0x57, 0xd3, 0xef, 0xf7}
+	spanID  = trace.SpanID{0xe4, 0x57, 0xb5, 0xa2, 0xe4, 0xd8, 0x6b, 0xd1}
+)
+
+func TestB3SingleFromRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header string
+		ok     bool
+		opts   trace.TraceOptions
+	}{
+		{"ids only", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1", true, 0},
+		{"sampled", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1", true, 1},
+		{"not sampled", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0", true, 0},
+		{"debug", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-d", true, 1},
+		{"with parent", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90", true, 1},
+		{"64 bit trace id", "64fe8b2a57d3eff7-e457b5a2e4d86bd1-1", true, 1},
+		{"missing", "", false, 0},
+		{"sampling state only", "0", false, 0},
+		{"debug only", "d", false, 0},
+		{"bad sampling state", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-x", false, 0},
+		{"bad trace id", "zzf198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1", false, 0},
+		{"bad span id", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bzz-1", false, 0},
+		{"too many parts", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90-1", false, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "http://example.com", nil)
+			if tc.header != "" {
+				r.Header.Set(B3SingleHeader, tc.header)
+			}
+			sc, ok := (&B3SingleFormat{}).SpanContextFromRequest(r)
+			if ok != tc.ok {
+				t.Fatalf("ok = %v, want %v", ok, tc.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.SpanID != spanID {
+				t.Errorf("span id = %x, want %x", sc.SpanID, spanID)
+			}
+			if !bytes.Equal(sc.TraceID[8:], traceID[8:]) {
+				t.Errorf("trace id = %x, want it to end with %x", sc.TraceID, traceID[8:])
+			}
+			if sc.TraceOptions != tc.opts {
+				t.Errorf("trace options = %v, want %v", sc.TraceOptions, tc.opts)
+			}
+		})
+	}
+}
+
+func TestB3SingleRoundTrip(t *testing.T) {
+	for _, opts := range []trace.TraceOptions{0, 1} {
+		in := trace.SpanContext{TraceID: traceID, SpanID: spanID, TraceOptions: opts}
+		r, _ := http.NewRequest("GET", "http://example.com", nil)
+		(&B3SingleFormat{}).SpanContextToRequest(in, r)
+		out, ok := (&B3SingleFormat{}).SpanContextFromRequest(r)
+		if !ok || out != in {
+			t.Errorf("%s: got %+v, %v, want %+v", r.Header.Get(B3SingleHeader), out, ok, in)
+		}
+	}
+}
+
+func TestParseB3SamplingState(t *testing.T) {
+	for _, tc := range []struct {
+		s           string
+		sampled, ok bool
+	}{
+		{"1", true, true},
+		{"d", true, true},
+		{"0", false, true},
+		{"", false, false},
+		{"true", false, false},
+		{"D", false, false},
+	} {
+		if sampled, ok := ParseB3SamplingState(tc.s); sampled != tc.sampled || ok != tc.ok {
+			t.Errorf("ParseB3SamplingState(%q) = %v, %v, want %v, %v", tc.s, sampled, ok, tc.sampled, tc.ok)
+		}
+	}
+}
+
+func TestFormatExtractOrder(t *testing.T) {
+	f, err := New(DefaultInject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	r.Header.Set(B3SingleHeader, "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1")
+	sc, ok := f.SpanContextFromRequest(r)
+	if !ok || sc.TraceID[0] != 0x0a {
+		t.Errorf("got %v, %v, want the traceparent span context", sc.TraceID, ok)
+	}
+
+	r.Header.Del("traceparent")
+	if sc, ok := f.SpanContextFromRequest(r); !ok || sc.TraceID != traceID {
+		t.Errorf("got %v, %v, want the b3 span context", sc.TraceID, ok)
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New("tracecontext,nope"); err == nil {
+		t.Error("New with an unknown format didn't fail")
+	}
+	f, err := New(" B3Single , ,tracecontext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Inject) != 2 {
+		t.Errorf("%d formats injected, want 2", len(f.Inject))
+	}
+}
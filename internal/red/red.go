@@ -0,0 +1,144 @@
+// Package red instruments HTTP handlers for Rate, Errors and Duration (RED):
+// access logs, Prometheus histograms and OpenCensus route tags, all in one
+// place.
+package red
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/freeformz/goobser/internal/logging"
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/freeformz/goobser/internal/response"
+	"github.com/freeformz/goobser/internal/semconv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+// Handler is a http.Handler that instruments Handler. The status, size and
+// timing of the response are captured by wrapping the ResponseWriter (see
+// response.Wrap), so they are correct even when Handler doesn't keep track of
+// them. Any of Route, Log, Durations and Sizes can be left unset to skip that
+// part of the instrumentation.
+type Handler struct {
+	// Handler is the handler being instrumented.
+	Handler http.Handler
+
+	// Route is added to the request's OpenCensus tags (ochttp.WithRouteTag),
+	// to the current span as the "http.route" attribute and to the access
+	// line.
+	Route string
+
+	// Log is used to create the request's logger (see logging.Middleware),
+	// which writes an access line once the request completes.
+	Log logrus.FieldLogger
+
+	// Durations observes the request duration in seconds and Sizes the
+	// response body size in bytes. Both must have a "code" label, any other
+	// labels need to be curried. When the request's span is sampled the
+	// observations carry its trace ID as an exemplar (see
+	// prometheus.ExemplarObserver), which is only exposed when /metrics serves
+	// OpenMetrics. Requests whose connection was hijacked (e.g. websocket
+	// upgrades) have neither a status nor a meaningful duration, so they
+	// aren't observed, only logged.
+	Durations prometheus.ObserverVec
+	Sizes     prometheus.ObserverVec
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw, rec := response.Wrap(w)
+
+	if h.Log != nil {
+		fields := logrus.Fields{
+			"method": r.Method,
+			"path":   r.URL.String(),
+		}
+		if h.Route != "" {
+			fields["route"] = h.Route
+		}
+		r = r.WithContext(logging.NewContext(r.Context(), h.Log.WithFields(fields)))
+	}
+
+	next := h.Handler
+	if h.Route != "" {
+		trace.FromContext(r.Context()).AddAttributes(trace.StringAttribute(semconv.HTTPRoute, h.Route))
+		next = ochttp.WithRouteTag(next, h.Route)
+	}
+
+	defer func() {
+		p := recover()
+		status := rec.Status()
+		if p != nil {
+			status = http.StatusInternalServerError
+		}
+		h.observe(r, rec, status, p)
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	next.ServeHTTP(rw, r)
+}
+
+func (h *Handler) observe(r *http.Request, rec *response.Recorder, status int, p interface{}) {
+	d := time.Since(rec.Start())
+	if rec.Hijacked() && p == nil {
+		if h.Log != nil {
+			logging.FromContext(r.Context()).WithFields(logrus.Fields{
+				loglevel.ComponentKey: "access",
+				"hijacked":            true,
+				"duration":            d.Seconds(),
+			}).Info()
+		}
+		return
+	}
+	code := strconv.Itoa(status)
+	ex := exemplar(r)
+	if h.Durations != nil {
+		observe(h.Durations.With(prometheus.Labels{"code": code}), d.Seconds(), ex)
+	}
+	if h.Sizes != nil {
+		observe(h.Sizes.With(prometheus.Labels{"code": code}), float64(rec.Bytes()), ex)
+	}
+	if h.Log != nil {
+		log := logging.FromContext(r.Context()).WithFields(logrus.Fields{
+			loglevel.ComponentKey: "access",
+			"status":              status,
+			"duration":            d.Seconds(),
+			"bytes":               rec.Bytes(),
+		})
+		if p != nil {
+			log.WithField("panic", p).Error()
+			return
+		}
+		log.Info()
+	}
+}
+
+// TraceIDLabel is the exemplar label holding the trace ID.
+const TraceIDLabel = "trace_id"
+
+// exemplar returns the exemplar labels for r, or nil when r's span isn't
+// sampled and so won't be found in the tracing backend.
+func exemplar(r *http.Request) prometheus.Labels {
+	span := trace.FromContext(r.Context())
+	if span == nil {
+		return nil
+	}
+	sc := span.SpanContext()
+	if !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{TraceIDLabel: sc.TraceID.String()}
+}
+
+func observe(o prometheus.Observer, v float64, ex prometheus.Labels) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && ex != nil {
+		eo.ObserveWithExemplar(v, ex)
+		return
+	}
+	o.Observe(v)
+}
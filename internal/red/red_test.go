@@ -0,0 +1,82 @@
+package red
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+// hijackable is a ResponseWriter whose connection can be hijacked.
+type hijackable struct{ *httptest.ResponseRecorder }
+
+func (hijackable) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+
+func TestHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		code    string // observed, "" if nothing is
+		access  logrus.Fields
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			"200", logrus.Fields{"status": 200., "bytes": 5., "route": "/"}},
+		{"error", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "nope", http.StatusServiceUnavailable) },
+			"503", logrus.Fields{"status": 503., "bytes": 5.}},
+		{"panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			"500", logrus.Fields{"status": 500., "panic": "boom", "level": "error"}},
+		{"hijacked", func(w http.ResponseWriter, r *http.Request) { w.(http.Hijacker).Hijack() },
+			"", logrus.Fields{"hijacked": true, "level": "info"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			durs := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "durations"}, []string{"code"})
+			sizes := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "sizes"}, []string{"code"})
+			var out bytes.Buffer
+			log := logrus.New()
+			log.Out = &out
+			log.Formatter = &logrus.JSONFormatter{}
+
+			h := &Handler{Handler: tc.handler, Route: "/", Log: log, Durations: durs, Sizes: sizes}
+			func() {
+				defer func() { recover() }()
+				h.ServeHTTP(hijackable{httptest.NewRecorder()}, httptest.NewRequest("GET", "/", nil))
+			}()
+
+			for _, v := range []*prometheus.HistogramVec{durs, sizes} {
+				want := 0
+				if tc.code != "" {
+					want = 1
+				}
+				if n := testutil.CollectAndCount(v); n != want {
+					t.Errorf("%d series observed, want %d", n, want)
+				}
+				if tc.code != "" && !v.DeleteLabelValues(tc.code) {
+					t.Errorf("code %s not observed", tc.code)
+				}
+			}
+
+			var access logrus.Fields
+			if err := json.Unmarshal(out.Bytes(), &access); err != nil {
+				t.Fatalf("access line %q: %v", out.String(), err)
+			}
+			if access["component"] != "access" {
+				t.Errorf("component = %v, want access", access["component"])
+			}
+			for k, v := range tc.access {
+				if access[k] != v {
+					t.Errorf("%s = %v, want %v", k, access[k], v)
+				}
+			}
+			if _, ok := access["status"]; tc.code == "" && ok {
+				t.Errorf("status = %v, want none", access["status"])
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package requestid gives every request an id, which is propagated to the
+// response, to outbound requests made while handling it and to anything else
+// with access to the request's context.
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Defaults used when Handler or Transport don't specify otherwise.
+const (
+	DefaultHeader    = "X-Request-ID"
+	DefaultMaxLength = 128
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx that carries id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request id carried by ctx, or "" if there isn't one.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// NewUUID is the default generator, it returns a random (version 4) UUID.
+func NewUUID() string {
+	return uuid.New().String()
+}
+
+// Valid reports whether id is non empty, no longer than maxLength and only
+// contains ASCII letters, digits and the characters '-', '_', '.', ':' and
+// '/'. This rules out ids that could be used to inject content into logs or
+// headers.
+func Valid(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Handler is a http.Handler that reads the request id from the incoming
+// request, or generates a new one if it is missing or rejected. The id is
+// added to the request's context and is set on both the request and the
+// response headers.
+type Handler struct {
+	// Handler is the handler that is called with the request id available.
+	Handler http.Handler
+
+	// Header is the name of the header holding the request id. Defaults to
+	// DefaultHeader.
+	Header string
+
+	// Generate returns a new request id. Defaults to NewUUID.
+	Generate func() string
+
+	// MaxLength of an incoming request id. Longer ids are rejected. Defaults
+	// to DefaultMaxLength.
+	MaxLength int
+
+	// Validate reports whether an incoming request id is acceptable. Defaults
+	// to Valid with MaxLength.
+	Validate func(id string) bool
+
+	// Trust reports whether the request comes from a source whose request ids
+	// should be used at all. Defaults to trusting every request.
+	Trust func(r *http.Request) bool
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := h.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	id := r.Header.Get(header)
+	if id != "" && !h.accept(r, id) {
+		id = ""
+	}
+	if id == "" {
+		if h.Generate != nil {
+			id = h.Generate()
+		} else {
+			id = NewUUID()
+		}
+	}
+
+	r.Header.Set(header, id)
+	w.Header().Set(header, id)
+	h.Handler.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+}
+
+func (h *Handler) accept(r *http.Request, id string) bool {
+	if h.Trust != nil && !h.Trust(r) {
+		return false
+	}
+	if h.Validate != nil {
+		return h.Validate(id)
+	}
+	max := h.MaxLength
+	if max <= 0 {
+		max = DefaultMaxLength
+	}
+	return Valid(id, max)
+}
+
+// Transport is a http.RoundTripper that sets the request id carried by an
+// outbound request's context on the request's headers, unless the request
+// already has one.
+type Transport struct {
+	// Base is the RoundTripper used to make the request. Defaults to
+	// http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Header is the name of the header holding the request id. Defaults to
+	// DefaultHeader.
+	Header string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(header) != "" {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request, so set the header on a copy.
+	r := req.WithContext(req.Context())
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set(header, id)
+	return base.RoundTrip(r)
+}
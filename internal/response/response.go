@@ -0,0 +1,169 @@
+// Package response captures the status, size and timing of HTTP responses.
+package response
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Recorder holds what was captured about a response. Its methods must not be
+// called concurrently with the handler writing the response, read it once the
+// handler has returned.
+type Recorder struct {
+	start    time.Time
+	header   time.Time // when the header was written
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+// Status returns the status code of the response. If the handler didn't write
+// anything this is 200, as that is what net/http responds with.
+func (r *Recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// WroteHeader reports whether the handler wrote the header, explicitly or by
+// writing the body.
+func (r *Recorder) WroteHeader() bool {
+	return r.status != 0
+}
+
+// Bytes returns the number of body bytes written.
+func (r *Recorder) Bytes() int64 {
+	return r.bytes
+}
+
+// Hijacked reports whether the handler took over the connection.
+func (r *Recorder) Hijacked() bool {
+	return r.hijacked
+}
+
+// Start returns when the ResponseWriter was wrapped.
+func (r *Recorder) Start() time.Time {
+	return r.start
+}
+
+// TimeToHeader returns how long after Start the header was written, or 0 if it
+// wasn't.
+func (r *Recorder) TimeToHeader() time.Duration {
+	if r.header.IsZero() {
+		return 0
+	}
+	return r.header.Sub(r.start)
+}
+
+// Wrap returns a ResponseWriter that records what is written through it to the
+// returned Recorder. The returned ResponseWriter implements http.Flusher,
+// http.Hijacker and http.Pusher if, and only if, w does, so handlers checking
+// for them behave the same as they do with w.
+func Wrap(w http.ResponseWriter) (http.ResponseWriter, *Recorder) {
+	rec := &Recorder{start: time.Now()}
+	b := &base{w: w, rec: rec}
+
+	f, isF := w.(http.Flusher)
+	h, isH := w.(http.Hijacker)
+	p, isP := w.(http.Pusher)
+	switch {
+	case isF && isH && isP:
+		return struct {
+			*base
+			flusher
+			hijacker
+			http.Pusher
+		}{b, flusher{b, f}, hijacker{b, h}, p}, rec
+	case isF && isH:
+		return struct {
+			*base
+			flusher
+			hijacker
+		}{b, flusher{b, f}, hijacker{b, h}}, rec
+	case isF && isP:
+		return struct {
+			*base
+			flusher
+			http.Pusher
+		}{b, flusher{b, f}, p}, rec
+	case isH && isP:
+		return struct {
+			*base
+			hijacker
+			http.Pusher
+		}{b, hijacker{b, h}, p}, rec
+	case isF:
+		return struct {
+			*base
+			flusher
+		}{b, flusher{b, f}}, rec
+	case isH:
+		return struct {
+			*base
+			hijacker
+		}{b, hijacker{b, h}}, rec
+	case isP:
+		return struct {
+			*base
+			http.Pusher
+		}{b, p}, rec
+	}
+	return b, rec
+}
+
+type base struct {
+	w   http.ResponseWriter
+	rec *Recorder
+}
+
+func (b *base) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *base) WriteHeader(status int) {
+	if b.rec.status == 0 {
+		b.rec.status = status
+		b.rec.header = time.Now()
+	}
+	b.w.WriteHeader(status)
+}
+
+func (b *base) Write(p []byte) (int, error) {
+	if b.rec.status == 0 { // implicit WriteHeader(http.StatusOK)
+		b.rec.status = http.StatusOK
+		b.rec.header = time.Now()
+	}
+	n, err := b.w.Write(p)
+	b.rec.bytes += int64(n)
+	return n, err
+}
+
+type flusher struct {
+	b *base
+	f http.Flusher
+}
+
+// Flush implies writing the header.
+func (f flusher) Flush() {
+	if f.b.rec.status == 0 {
+		f.b.rec.status = http.StatusOK
+		f.b.rec.header = time.Now()
+	}
+	f.f.Flush()
+}
+
+type hijacker struct {
+	b *base
+	h http.Hijacker
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := h.h.Hijack()
+	if err == nil {
+		h.b.rec.hijacked = true
+	}
+	return c, rw, err
+}
@@ -0,0 +1,151 @@
+package response
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The optional interfaces of a ResponseWriter, implemented by embedding.
+type (
+	writer struct{ http.ResponseWriter }
+
+	flushes struct{ flushed *bool }
+	hijacks struct{}
+	pushes  struct{}
+)
+
+func (f flushes) Flush() { *f.flushed = true }
+
+func (hijacks) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+
+func (pushes) Push(string, *http.PushOptions) error { return nil }
+
+// newWriter returns a ResponseWriter implementing http.Flusher, http.Hijacker
+// and http.Pusher as requested, recording to rr.
+func newWriter(rr *httptest.ResponseRecorder, flusher, hijacker, pusher bool, flushed *bool) http.ResponseWriter {
+	w := writer{rr}
+	f := flushes{flushed}
+	switch {
+	case flusher && hijacker && pusher:
+		return struct {
+			writer
+			flushes
+			hijacks
+			pushes
+		}{w, f, hijacks{}, pushes{}}
+	case flusher && hijacker:
+		return struct {
+			writer
+			flushes
+			hijacks
+		}{w, f, hijacks{}}
+	case flusher && pusher:
+		return struct {
+			writer
+			flushes
+			pushes
+		}{w, f, pushes{}}
+	case hijacker && pusher:
+		return struct {
+			writer
+			hijacks
+			pushes
+		}{w, hijacks{}, pushes{}}
+	case flusher:
+		return struct {
+			writer
+			flushes
+		}{w, f}
+	case hijacker:
+		return struct {
+			writer
+			hijacks
+		}{w, hijacks{}}
+	case pusher:
+		return struct {
+			writer
+			pushes
+		}{w, pushes{}}
+	}
+	return w
+}
+
+func TestWrapInterfaces(t *testing.T) {
+	for _, tc := range []struct {
+		name                      string
+		flusher, hijacker, pusher bool
+	}{
+		{"none", false, false, false},
+		{"flusher", true, false, false},
+		{"hijacker", false, true, false},
+		{"pusher", false, false, true},
+		{"flusher hijacker", true, true, false},
+		{"flusher pusher", true, false, true},
+		{"hijacker pusher", false, true, true},
+		{"all", true, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var flushed bool
+			rw, rec := Wrap(newWriter(httptest.NewRecorder(), tc.flusher, tc.hijacker, tc.pusher, &flushed))
+
+			f, isF := rw.(http.Flusher)
+			h, isH := rw.(http.Hijacker)
+			_, isP := rw.(http.Pusher)
+			if isF != tc.flusher || isH != tc.hijacker || isP != tc.pusher {
+				t.Fatalf("Flusher, Hijacker, Pusher = %v, %v, %v, want %v, %v, %v", isF, isH, isP, tc.flusher, tc.hijacker, tc.pusher)
+			}
+			if isF {
+				f.Flush()
+				if !flushed {
+					t.Error("Flush wasn't passed through")
+				}
+				if !rec.WroteHeader() || rec.Status() != http.StatusOK {
+					t.Errorf("after Flush WroteHeader, Status = %v, %d, want true, 200", rec.WroteHeader(), rec.Status())
+				}
+			}
+			if isH {
+				if _, _, err := h.Hijack(); err != nil {
+					t.Fatal(err)
+				}
+				if !rec.Hijacked() {
+					t.Error("Hijack wasn't recorded")
+				}
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		handle func(w http.ResponseWriter)
+		status int
+		bytes  int64
+		header bool
+	}{
+		{"nothing written", func(w http.ResponseWriter) {}, http.StatusOK, 0, false},
+		{"implicit 200", func(w http.ResponseWriter) { w.Write([]byte("hello")) }, http.StatusOK, 5, true},
+		{"explicit status", func(w http.ResponseWriter) { http.Error(w, "nope", http.StatusTeapot) }, http.StatusTeapot, 5, true},
+		{"first status wins", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("a"))
+			w.Write([]byte("bc"))
+		}, http.StatusNotFound, 3, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			rw, rec := Wrap(rr)
+			tc.handle(rw)
+			if rec.Status() != tc.status || rec.Bytes() != tc.bytes || rec.WroteHeader() != tc.header {
+				t.Errorf("Status, Bytes, WroteHeader = %d, %d, %v, want %d, %d, %v", rec.Status(), rec.Bytes(), rec.WroteHeader(), tc.status, tc.bytes, tc.header)
+			}
+			if int64(rr.Body.Len()) != tc.bytes {
+				t.Errorf("%d bytes reached the wrapped ResponseWriter, want %d", rr.Body.Len(), tc.bytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package sampling
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rule kinds.
+const (
+	Always      = "always"
+	Never       = "never"
+	Probability = "probability" // Value is the fraction of traces sampled
+	Rate        = "rate"        // Value is the number of traces sampled per second
+)
+
+// Rule describes how traces are sampled. Its text form is one of "always",
+// "never", "probability:<fraction>" or "rate:<traces per second>".
+type Rule struct {
+	Kind  string
+	Value float64
+}
+
+// ParseRule parses the text form of a Rule.
+func ParseRule(s string) (Rule, error) {
+	kind, value := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		kind, value = s[:i], s[i+1:]
+	}
+	kind = strings.ToLower(strings.TrimSpace(kind))
+
+	switch kind {
+	case Always, Never:
+		if value != "" {
+			return Rule{}, fmt.Errorf("sampling: %q doesn't take a value", kind)
+		}
+		return Rule{Kind: kind}, nil
+	case Probability, Rate:
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return Rule{}, fmt.Errorf("sampling: invalid %s value %q", kind, value)
+		}
+		if v < 0 || (kind == Probability && v > 1) {
+			return Rule{}, fmt.Errorf("sampling: %s value %v out of range", kind, v)
+		}
+		return Rule{Kind: kind, Value: v}, nil
+	default:
+		return Rule{}, fmt.Errorf("sampling: unknown rule %q", s)
+	}
+}
+
+func (r Rule) String() string {
+	switch r.Kind {
+	case Probability, Rate:
+		return r.Kind + ":" + strconv.FormatFloat(r.Value, 'g', -1, 64)
+	default:
+		return r.Kind
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Rule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Set implements flag.Value.
+func (r *Rule) Set(s string) error {
+	v, err := ParseRule(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// Routes are per route Rules, keyed by route. Their text form is a comma
+// separated list of route=rule pairs, e.g. "/slow=always,/=rate:5".
+type Routes map[string]Rule
+
+// ParseRoutes parses the text form of Routes.
+func ParseRoutes(s string) (Routes, error) {
+	routes := make(Routes)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		i := strings.LastIndexByte(p, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("sampling: route rule %q isn't route=rule", p)
+		}
+		r, err := ParseRule(p[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.TrimSpace(p[:i])] = r
+	}
+	return routes, nil
+}
+
+func (rs Routes) String() string {
+	keys := make([]string, 0, len(rs))
+	for k := range rs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + rs[k].String()
+	}
+	return strings.Join(keys, ",")
+}
+
+// Set implements flag.Value.
+func (rs *Routes) Set(s string) error {
+	v, err := ParseRoutes(s)
+	if err != nil {
+		return err
+	}
+	*rs = v
+	return nil
+}
+
+// Config of a Sampler.
+type Config struct {
+	// Default is the Rule used when no other one applies.
+	Default Rule `json:"default"`
+
+	// Routes override Default for some routes.
+	Routes Routes `json:"routes"`
+
+	// ParentBased makes requests follow the sampling decision of their
+	// remote parent when it has one, e.g. from the X-B3-Sampled or
+	// traceparent headers.
+	ParentBased bool `json:"parent_based"`
+
+	// DebugHeader is the name of a request header that forces sampling of
+	// the request when set to a non empty value. Empty disables it. Any
+	// caller can set it, see the package documentation before enabling it.
+	DebugHeader string `json:"debug_header"`
+}
+
+// DefaultConfig samples 10% of traces, following the decision of the parent.
+// The DebugHeader is disabled.
+var DefaultConfig = Config{
+	Default:     Rule{Kind: Probability, Value: 0.1},
+	ParentBased: true,
+}
+
+// Environment variables read by RegisterFlags to override the defaults of the
+// flags.
+const (
+	EnvSampler     = "TRACE_SAMPLER"        // -trace-sampler
+	EnvRoutes      = "TRACE_SAMPLER_ROUTES" // -trace-sampler-routes
+	EnvParentBased = "TRACE_SAMPLER_PARENT" // -trace-sampler-parent
+	EnvDebugHeader = "TRACE_DEBUG_HEADER"   // -trace-debug-header
+)
+
+// RegisterFlags registers the flags configuring c on fs. The flags default to
+// the value of their environment variable (see EnvSampler, etc), or to the
+// current value of c when it's unset. An invalid environment variable is an
+// error.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) error {
+	if v, ok := os.LookupEnv(EnvSampler); ok {
+		if err := c.Default.Set(v); err != nil {
+			return fmt.Errorf("%s: %v", EnvSampler, err)
+		}
+	}
+	if v, ok := os.LookupEnv(EnvRoutes); ok {
+		if err := c.Routes.Set(v); err != nil {
+			return fmt.Errorf("%s: %v", EnvRoutes, err)
+		}
+	}
+	if v, ok := os.LookupEnv(EnvParentBased); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvParentBased, err)
+		}
+		c.ParentBased = b
+	}
+	if v, ok := os.LookupEnv(EnvDebugHeader); ok {
+		c.DebugHeader = v
+	}
+
+	fs.Var(&c.Default, "trace-sampler", "default trace sampling `rule`: always, never, probability:<fraction> or rate:<per second>")
+	fs.Var(&c.Routes, "trace-sampler-routes", "per route sampling rules, e.g. /slow=always,/=rate:5")
+	fs.BoolVar(&c.ParentBased, "trace-sampler-parent", c.ParentBased, "follow the sampling decision of the remote parent")
+	fs.StringVar(&c.DebugHeader, "trace-debug-header", c.DebugHeader, "request `header` forcing a request to be sampled, empty to disable; only set it when callers are trusted")
+	return nil
+}
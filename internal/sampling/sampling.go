@@ -0,0 +1,245 @@
+// Package sampling provides configurable OpenCensus trace samplers:
+// probability or rate limited, with per route overrides, following the
+// decision of a remote parent and forced by a debug header.
+//
+// The debug header, the B3 debug flag and the remote parent's decision are all
+// set by the caller, so any client that can reach a service can use them to
+// get every one of its requests sampled, getting around a rate limit and
+// flooding the exporter. That's why the debug header is disabled by default and
+// the B3 debug flag is only honored along with the parent's decision
+// (ParentBased). Only enable either for services whose callers are trusted, or
+// strip those headers from external requests at the edge.
+package sampling
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freeformz/goobser/internal/propagation"
+	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/trace"
+)
+
+// Sampler makes sampling decisions according to its Config.
+type Sampler struct {
+	// Route returns the route of a request, used to look up the per route
+	// Rules. If nil the request's URL path is used. With a http.ServeMux use
+	// the pattern returned by its Handler method.
+	Route func(*http.Request) string
+
+	cfg    Config
+	def    trace.Sampler
+	routes map[string]trace.Sampler
+}
+
+// New Sampler for cfg.
+func New(cfg Config) *Sampler {
+	s := &Sampler{
+		cfg:    cfg,
+		def:    sampler(cfg.Default),
+		routes: make(map[string]trace.Sampler, len(cfg.Routes)),
+	}
+	for route, r := range cfg.Routes {
+		s.routes[route] = sampler(r)
+	}
+	return s
+}
+
+// Config returns the Sampler's configuration.
+func (s *Sampler) Config() Config {
+	return s.cfg
+}
+
+// Sampler returns the trace.Sampler to use as trace.Config's DefaultSampler.
+// It applies the Default rule, following the decision of a remote parent when
+// ParentBased is set.
+func (s *Sampler) Sampler() trace.Sampler {
+	return s.parentBased(s.def)
+}
+
+// StartOptions returns the trace.StartOptions for r, to be used as
+// ochttp.Handler's GetStartOptions.
+//
+// Requests carrying the DebugHeader, if set, are always sampled. When
+// ParentBased is set requests with a B3 debug flag (X-B3-Flags: 1 or a "d" b3
+// sampling state) are too, and an explicit decision of the caller
+// (X-B3-Sampled, b3 or traceparent headers) is followed. Otherwise the rule of
+// the request's route, or the Default one, applies.
+func (s *Sampler) StartOptions(r *http.Request) trace.StartOptions {
+	if s.cfg.DebugHeader != "" && r.Header.Get(s.cfg.DebugHeader) != "" {
+		return trace.StartOptions{Sampler: trace.AlwaysSample()}
+	}
+
+	if s.cfg.ParentBased {
+		if r.Header.Get("X-B3-Flags") == "1" || b3State(r) == "d" {
+			return trace.StartOptions{Sampler: trace.AlwaysSample()}
+		}
+		if sampled, ok := remoteDecision(r); ok {
+			if sampled {
+				return trace.StartOptions{Sampler: trace.AlwaysSample()}
+			}
+			return trace.StartOptions{Sampler: trace.NeverSample()}
+		}
+	}
+
+	route := r.URL.Path
+	if s.Route != nil {
+		route = s.Route(r)
+	}
+	if rs, ok := s.routes[route]; ok {
+		return trace.StartOptions{Sampler: s.parentBased(rs)}
+	}
+	return trace.StartOptions{Sampler: s.Sampler()}
+}
+
+// remoteDecision returns the sampling decision made by the caller of r, if it
+// made an explicit one. It's taken from the headers the span context is
+// extracted from (see propagation.Extractors), so that it belongs to the trace
+// the request joins. Without a span context the b3 and X-B3-Sampled headers
+// can still carry a decision on their own.
+func remoteDecision(r *http.Request) (sampled, ok bool) {
+	for _, hf := range propagation.Extractors() {
+		sc, ok := hf.SpanContextFromRequest(r)
+		if !ok {
+			continue
+		}
+		switch hf.(type) {
+		case *propagation.B3SingleFormat:
+			return propagation.ParseB3SamplingState(b3State(r))
+		case *b3.HTTPFormat:
+			return b3Sampled(r)
+		default: // traceparent always has the sampled flag
+			return sc.IsSampled(), true
+		}
+	}
+	if sampled, ok := propagation.ParseB3SamplingState(b3State(r)); ok {
+		return sampled, true
+	}
+	return b3Sampled(r)
+}
+
+// b3Sampled returns the decision of r's X-B3-Sampled header, if it has one.
+func b3Sampled(r *http.Request) (sampled, ok bool) {
+	switch strings.ToLower(r.Header.Get("X-B3-Sampled")) {
+	case "1", "true":
+		return true, true
+	case "0", "false":
+		return false, true
+	}
+	return false, false
+}
+
+// b3State returns the sampling state of r's b3 single header, either its
+// third part or its only one.
+func b3State(r *http.Request) string {
+	p := strings.Split(r.Header.Get(propagation.B3SingleHeader), "-")
+	switch len(p) {
+	case 1:
+		return p[0]
+	case 3, 4:
+		return p[2]
+	default:
+		return ""
+	}
+}
+
+// parentBased wraps ts so that it samples when the remote parent is sampled,
+// if ParentBased is set.
+func (s *Sampler) parentBased(ts trace.Sampler) trace.Sampler {
+	if !s.cfg.ParentBased {
+		return ts
+	}
+	return func(p trace.SamplingParameters) trace.SamplingDecision {
+		if p.HasRemoteParent && p.ParentContext.IsSampled() {
+			return trace.SamplingDecision{Sample: true}
+		}
+		return ts(p)
+	}
+}
+
+// ServeHTTP responds to GET & HEAD requests with the Sampler's configuration
+// as JSON.
+func (s *Sampler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func sampler(r Rule) trace.Sampler {
+	switch r.Kind {
+	case Always:
+		return trace.AlwaysSample()
+	case Probability:
+		return probability(r.Value)
+	case Rate:
+		return RateLimited(r.Value)
+	case Never:
+		return trace.NeverSample()
+	default:
+		panic(fmt.Sprintf("sampling: unknown rule %q", r.Kind))
+	}
+}
+
+// probability is like trace.ProbabilitySampler, but leaves following the
+// parent's decision to parentBased.
+func probability(fraction float64) trace.Sampler {
+	if fraction >= 1 {
+		return trace.AlwaysSample()
+	}
+	bound := uint64(fraction * (1 << 63))
+	return func(p trace.SamplingParameters) trace.SamplingDecision {
+		x := binary.BigEndian.Uint64(p.TraceID[0:8]) >> 1
+		return trace.SamplingDecision{Sample: x < bound}
+	}
+}
+
+// RateLimited returns a trace.Sampler that samples at most perSecond traces a
+// second, allowing bursts of up to perSecond traces (at least 1).
+func RateLimited(perSecond float64) trace.Sampler {
+	burst := perSecond
+	if burst < 1 {
+		burst = 1
+	}
+	b := &bucket{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}
+	return func(trace.SamplingParameters) trace.SamplingDecision {
+		return trace.SamplingDecision{Sample: b.take()}
+	}
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // max tokens
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,95 @@
+package sampling
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opencensus.io/trace"
+)
+
+// sampled reports whether the sampler of opts samples a new root span.
+func sampled(opts trace.StartOptions) bool {
+	return opts.Sampler(trace.SamplingParameters{}).Sample
+}
+
+func TestStartOptionsForced(t *testing.T) {
+	never := Rule{Kind: Never}
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		headers map[string]string
+		sampled bool
+	}{
+		{"nothing", Config{Default: never}, nil, false},
+		{"debug header disabled", Config{Default: never}, map[string]string{"X-Trace-Debug": "1"}, false},
+		{"debug header", Config{Default: never, DebugHeader: "X-Trace-Debug"}, map[string]string{"X-Trace-Debug": "1"}, true},
+		{"empty debug header", Config{Default: never, DebugHeader: "X-Trace-Debug"}, map[string]string{"X-Trace-Debug": ""}, false},
+		{"b3 flags without parent based", Config{Default: never}, map[string]string{"X-B3-Flags": "1"}, false},
+		{"b3 flags", Config{Default: never, ParentBased: true}, map[string]string{"X-B3-Flags": "1"}, true},
+		{"b3 debug state without parent based", Config{Default: never}, map[string]string{"b3": "d"}, false},
+		{"b3 debug state", Config{Default: never, ParentBased: true}, map[string]string{"b3": "d"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "http://example.com/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			if got := sampled(New(tc.cfg).StartOptions(r)); got != tc.sampled {
+				t.Errorf("sampled = %v, want %v", got, tc.sampled)
+			}
+		})
+	}
+}
+
+func TestDefaultConfigDebugHeader(t *testing.T) {
+	if DefaultConfig.DebugHeader != "" {
+		t.Errorf("DefaultConfig.DebugHeader = %q, want it disabled", DefaultConfig.DebugHeader)
+	}
+}
+
+func TestRemoteDecision(t *testing.T) {
+	const (
+		traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-"
+		b3          = "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"
+	)
+	b3Multi := func(sampled string) map[string]string {
+		return map[string]string{"X-B3-TraceId": "80f198ee56343ba864fe8b2a57d3eff7", "X-B3-SpanId": "e457b5a2e4d86bd1", "X-B3-Sampled": sampled}
+	}
+	with := func(m map[string]string, k, v string) map[string]string {
+		m[k] = v
+		return m
+	}
+	for _, tc := range []struct {
+		name        string
+		headers     map[string]string
+		sampled, ok bool
+	}{
+		{"none", nil, false, false},
+		{"traceparent sampled", map[string]string{"traceparent": traceparent + "01"}, true, true},
+		{"traceparent not sampled", map[string]string{"traceparent": traceparent + "00"}, false, true},
+		{"b3 sampled", map[string]string{"b3": b3 + "-1"}, true, true},
+		{"b3 ids only", map[string]string{"b3": b3}, false, false},
+		{"b3 decision only", map[string]string{"b3": "0"}, false, true},
+		{"x-b3 sampled", b3Multi("1"), true, true},
+		{"x-b3 ids only", b3Multi(""), false, false},
+		{"x-b3 decision only", map[string]string{"X-B3-Sampled": "true"}, true, true},
+
+		// the decision comes from the headers the span context is extracted from
+		{"traceparent over x-b3", with(b3Multi("0"), "traceparent", traceparent+"01"), true, true},
+		{"traceparent over x-b3, not sampled", with(b3Multi("1"), "traceparent", traceparent+"00"), false, true},
+		{"traceparent over b3", map[string]string{"traceparent": traceparent + "00", "b3": b3 + "-1"}, false, true},
+		{"b3 over x-b3", with(b3Multi("1"), "b3", b3+"-0"), false, true},
+		{"b3 ids only over x-b3", with(b3Multi("1"), "b3", b3), false, false},
+		{"invalid traceparent falls back", with(b3Multi("1"), "traceparent", "00-nope-01"), true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "http://example.com/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			if sampled, ok := remoteDecision(r); sampled != tc.sampled || ok != tc.ok {
+				t.Errorf("remoteDecision() = %v, %v, want %v, %v", sampled, ok, tc.sampled, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Package semconv sets the standard HTTP span attributes
+// (https://github.com/open-telemetry/opentelemetry-specification/blob/master/specification/trace/semantic_conventions/http.md)
+// and maps HTTP status codes to trace statuses the same way ochttp does, so
+// that the spans started by hand look like the ones started by ochttp.
+package semconv
+
+import (
+	"net/http"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+// Attribute keys.
+const (
+	HTTPMethod     = "http.method"
+	HTTPRoute      = "http.route"
+	HTTPStatusCode = "http.status_code"
+	HTTPURL        = "http.url"
+	PeerService    = "peer.service"
+	NetPeerName    = "net.peer.name"
+)
+
+// Server sets the attributes of a span handling r, whose route is route (if
+// not empty).
+func Server(span *trace.Span, r *http.Request, route string) {
+	attrs := []trace.Attribute{trace.StringAttribute(HTTPMethod, r.Method)}
+	if route != "" {
+		attrs = append(attrs, trace.StringAttribute(HTTPRoute, route))
+	}
+	span.AddAttributes(attrs...)
+}
+
+// Client sets the attributes of a span making req to service (if not empty).
+func Client(span *trace.Span, req *http.Request, service string) {
+	attrs := []trace.Attribute{
+		trace.StringAttribute(HTTPMethod, req.Method),
+		trace.StringAttribute(HTTPURL, req.URL.String()),
+		trace.StringAttribute(NetPeerName, req.URL.Hostname()),
+	}
+	if service != "" {
+		attrs = append(attrs, trace.StringAttribute(PeerService, service))
+	}
+	span.AddAttributes(attrs...)
+}
+
+// Status returns the trace status of a response with status code code, as
+// set by ochttp on its client and server spans: 4xx and 5xx are errors, with
+// specific codes for the common ones (e.g. 400 is
+// trace.StatusCodeInvalidArgument).
+func Status(code int) trace.Status {
+	return ochttp.TraceStatus(code, http.StatusText(code))
+}
+
+// Response sets the status code attribute and the status of a span that
+// received or sent a response with status code code.
+func Response(span *trace.Span, code int) {
+	span.AddAttributes(trace.Int64Attribute(HTTPStatusCode, int64(code)))
+	span.SetStatus(Status(code))
+}
+
+// Transport is a http.RoundTripper that sets the client attributes (see Client)
+// on the span of the request's context. Use it as the Base of an
+// ochttp.Transport, so that they're set on the client span it starts rather
+// than on the caller's span.
+type Transport struct {
+	// Base is the RoundTripper used to make the request. Defaults to
+	// http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Service is the name of the service called, the peer.service attribute.
+	Service string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if span := trace.FromContext(req.Context()); span != nil {
+		Client(span, req, t.Service)
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,268 @@
+// Package spanfile provides an OpenCensus trace.Exporter writing spans as JSON
+// lines to a file, with size based rotation, or to stdout. It's meant for
+// capturing traces without a tracing backend and inspecting them later, with
+// jq for example.
+package spanfile
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+// Stdout is the path used to write spans to stdout.
+const Stdout = "-"
+
+// Defaults for Options.
+const (
+	DefaultMaxBytes   = 10 << 20 // 10MiB
+	DefaultMaxBackups = 3
+)
+
+// EnvExporter is the environment variable read by RegisterFlag to override the
+// default of the -trace-exporter flag.
+const EnvExporter = "TRACE_EXPORTER"
+
+// RegisterFlag registers the -trace-exporter flag on fs. Its value is
+// "jaeger", Stdout or the path of a file to export spans to. It defaults to
+// the value of EnvExporter, or to "jaeger" if it's unset.
+func RegisterFlag(fs *flag.FlagSet) *string {
+	def := "jaeger"
+	if v, ok := os.LookupEnv(EnvExporter); ok {
+		def = v
+	}
+	return fs.String("trace-exporter", def, "where to export spans: jaeger, - for stdout or a file `path`")
+}
+
+// Options of an Exporter.
+type Options struct {
+	// MaxBytes is the size a file can grow to before being rotated,
+	// DefaultMaxBytes if 0.
+	MaxBytes int64
+
+	// MaxBackups is the number of rotated files kept (path.1 being the most
+	// recent), DefaultMaxBackups if 0.
+	MaxBackups int
+
+	// OnError is called with errors encoding or writing spans. If nil they
+	// are logged with the standard logger.
+	OnError func(error)
+}
+
+// Exporter is a trace.Exporter writing one JSON object per span and line.
+type Exporter struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	w    io.Writer
+	f    *os.File // nil when writing to stdout
+	size int64
+}
+
+var _ trace.Exporter = (*Exporter)(nil)
+
+// New Exporter writing to the file at path, appending to it if it exists, or
+// to stdout if path is Stdout.
+func New(path string, opts Options) (*Exporter, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = DefaultMaxBackups
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(err error) {
+			log.Printf("spanfile: %v", err)
+		}
+	}
+
+	e := &Exporter{path: path, opts: opts}
+	if path == Stdout {
+		e.w = os.Stdout
+		return e, nil
+	}
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Exporter) open() error {
+	f, err := os.OpenFile(e.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.f, e.w, e.size = f, f, fi.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts path.N-1 to path.N, ..., path to
+// path.1 and opens a new file at path. A new file is opened even if shifting
+// the old ones failed, so spans keep being written.
+func (e *Exporter) rotate() error {
+	err := e.f.Close()
+	e.f, e.w = nil, nil
+	for i := e.opts.MaxBackups; i > 0 && err == nil; i-- {
+		from := e.path
+		if i > 1 {
+			from += "." + strconv.Itoa(i-1)
+		}
+		if rerr := os.Rename(from, e.path+"."+strconv.Itoa(i)); rerr != nil && !os.IsNotExist(rerr) {
+			err = rerr
+		}
+	}
+	if oerr := e.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// ExportSpan implements trace.Exporter.
+func (e *Exporter) ExportSpan(sd *trace.SpanData) {
+	b, err := json.Marshal(newSpan(sd))
+	if err != nil {
+		e.opts.OnError(err)
+		return
+	}
+	b = append(b, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.f != nil && e.size > 0 && e.size+int64(len(b)) > e.opts.MaxBytes {
+		if err := e.rotate(); err != nil {
+			e.opts.OnError(fmt.Errorf("rotating %s: %v", e.path, err))
+		}
+	}
+	if e.w == nil { // closed, or the file couldn't be reopened
+		return
+	}
+	n, err := e.w.Write(b)
+	e.size += int64(n)
+	if err != nil {
+		e.opts.OnError(err)
+	}
+}
+
+// Close the file being written to, if any. Spans exported afterwards are
+// dropped.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.f == nil {
+		return nil
+	}
+	err := e.f.Close()
+	e.f, e.w = nil, nil
+	return err
+}
+
+// span is the JSON encoding of a trace.SpanData.
+type span struct {
+	TraceID         string                 `json:"trace_id"`
+	SpanID          string                 `json:"span_id"`
+	ParentSpanID    string                 `json:"parent_span_id,omitempty"`
+	Name            string                 `json:"name"`
+	Kind            string                 `json:"kind"`
+	Sampled         bool                   `json:"sampled"`
+	HasRemoteParent bool                   `json:"has_remote_parent,omitempty"`
+	Start           time.Time              `json:"start"`
+	End             time.Time              `json:"end"`
+	Duration        float64                `json:"duration"` // seconds
+	Status          status                 `json:"status"`
+	Attributes      map[string]interface{} `json:"attributes,omitempty"`
+	Annotations     []annotation           `json:"annotations,omitempty"`
+	Links           []link                 `json:"links,omitempty"`
+	ChildSpanCount  int                    `json:"child_span_count"`
+	Dropped         map[string]int         `json:"dropped,omitempty"`
+}
+
+type status struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type annotation struct {
+	Time       time.Time              `json:"time"`
+	Message    string                 `json:"message,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type link struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	Type       string                 `json:"type"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func newSpan(sd *trace.SpanData) span {
+	s := span{
+		TraceID:         sd.TraceID.String(),
+		SpanID:          sd.SpanID.String(),
+		Name:            sd.Name,
+		Kind:            kind(sd.SpanKind),
+		Sampled:         sd.IsSampled(),
+		HasRemoteParent: sd.HasRemoteParent,
+		Start:           sd.StartTime,
+		End:             sd.EndTime,
+		Duration:        sd.EndTime.Sub(sd.StartTime).Seconds(),
+		Status:          status{Code: sd.Code, Message: sd.Message},
+		Attributes:      sd.Attributes,
+		ChildSpanCount:  sd.ChildSpanCount,
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		s.ParentSpanID = sd.ParentSpanID.String()
+	}
+	for _, a := range sd.Annotations {
+		s.Annotations = append(s.Annotations, annotation{Time: a.Time, Message: a.Message, Attributes: a.Attributes})
+	}
+	for _, l := range sd.Links {
+		lt := "unspecified"
+		switch l.Type {
+		case trace.LinkTypeChild:
+			lt = "child"
+		case trace.LinkTypeParent:
+			lt = "parent"
+		}
+		s.Links = append(s.Links, link{TraceID: l.TraceID.String(), SpanID: l.SpanID.String(), Type: lt, Attributes: l.Attributes})
+	}
+	for k, n := range map[string]int{
+		"attributes":     sd.DroppedAttributeCount,
+		"annotations":    sd.DroppedAnnotationCount,
+		"message_events": sd.DroppedMessageEventCount,
+		"links":          sd.DroppedLinkCount,
+	} {
+		if n > 0 {
+			if s.Dropped == nil {
+				s.Dropped = make(map[string]int)
+			}
+			s.Dropped[k] = n
+		}
+	}
+	return s
+}
+
+func kind(k int) string {
+	switch k {
+	case trace.SpanKindServer:
+		return "server"
+	case trace.SpanKindClient:
+		return "client"
+	default:
+		return "unspecified"
+	}
+}
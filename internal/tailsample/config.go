@@ -0,0 +1,69 @@
+package tailsample
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config of tail sampling, see RegisterFlags.
+type Config struct {
+	// Enabled turns tail sampling on.
+	Enabled bool
+	Options
+}
+
+// Environment variables read by RegisterFlags to override the defaults of the
+// flags.
+const (
+	EnvEnabled = "TRACE_TAIL"         // -trace-tail
+	EnvLatency = "TRACE_TAIL_LATENCY" // -trace-tail-latency
+	EnvRate    = "TRACE_TAIL_RATE"    // -trace-tail-rate
+	EnvWindow  = "TRACE_TAIL_WINDOW"  // -trace-tail-window
+)
+
+// RegisterFlags registers the flags configuring c on fs. The flags default to
+// the value of their environment variable (see EnvEnabled, etc), or to the
+// current value of c, or DefaultOptions, when it's unset. An invalid
+// environment variable is an error.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) error {
+	if c.Options == (Options{}) {
+		c.Options = DefaultOptions
+	}
+	if v, ok := os.LookupEnv(EnvEnabled); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvEnabled, err)
+		}
+		c.Enabled = b
+	}
+	if v, ok := os.LookupEnv(EnvLatency); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvLatency, err)
+		}
+		c.Latency = d
+	}
+	if v, ok := os.LookupEnv(EnvRate); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvRate, err)
+		}
+		c.Rate = f
+	}
+	if v, ok := os.LookupEnv(EnvWindow); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", EnvWindow, err)
+		}
+		c.Window = d
+	}
+
+	fs.BoolVar(&c.Enabled, "trace-tail", c.Enabled, "tail sample traces, keeping the ones with errors or a slow root")
+	fs.DurationVar(&c.Latency, "trace-tail-latency", c.Latency, "root span `duration` above which a trace is kept")
+	fs.Float64Var(&c.Rate, "trace-tail-rate", c.Rate, "`fraction` of the other traces kept, 0 for none")
+	fs.DurationVar(&c.Window, "trace-tail-window", c.Window, "how long spans are buffered waiting for their root span")
+	return nil
+}
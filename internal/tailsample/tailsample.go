@@ -0,0 +1,322 @@
+// Package tailsample provides a tail sampling OpenCensus trace.Exporter: it
+// buffers the spans of each trace and, once the trace is complete, exports
+// all or none of them depending on what happened in the trace. Unlike head
+// sampling (see the sampling package) that decides when a trace starts, it can
+// keep every trace with an error or a slow root.
+//
+// Spans only reach exporters when they are sampled, so use it with a sampler
+// that samples everything.
+package tailsample
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opencensus.io/trace"
+)
+
+// Options of an Exporter. Zero values, and a negative Rate, are replaced by the
+// DefaultOptions ones.
+type Options struct {
+	// Window is how long the spans of a trace are buffered, from its first
+	// span, if its local root span doesn't end before then.
+	Window time.Duration
+
+	// Latency is the duration above which a trace's local root is slow and
+	// the trace kept.
+	Latency time.Duration
+
+	// Rate is the fraction of the traces with neither errors nor a slow root
+	// that are kept. It's based on the trace ID, so services using the same
+	// Rate keep the same traces. Unlike the other options a Rate of 0 is kept
+	// as is: none of those traces are kept.
+	Rate float64
+
+	// MaxTraces is the number of traces buffered at once. When a new trace
+	// starts with the buffer full the oldest trace is decided early.
+	MaxTraces int
+
+	// MaxSpansPerTrace is the number of spans buffered per trace, spans over
+	// the limit are dropped.
+	MaxSpansPerTrace int
+}
+
+// DefaultOptions of an Exporter.
+var DefaultOptions = Options{
+	Window:           10 * time.Second,
+	Latency:          250 * time.Millisecond,
+	Rate:             0.01,
+	MaxTraces:        10000,
+	MaxSpansPerTrace: 1000,
+}
+
+// Reasons for a trace being kept or dropped, the value of the reason label of
+// the traces metric.
+const (
+	ReasonError     = "error"     // a span had a non-OK status
+	ReasonSlow      = "slow"      // the local root was slower than Latency
+	ReasonSampled   = "sampled"   // kept at Rate
+	ReasonUnsampled = "unsampled" // dropped at Rate
+)
+
+// Exporter is a trace.Exporter buffering spans per trace and exporting the
+// spans of the traces it keeps to the next exporter. It's also a
+// prometheus.Collector exposing how many traces were kept and dropped.
+type Exporter struct {
+	next trace.Exporter
+	opts Options
+
+	mu      sync.Mutex
+	pending map[trace.TraceID]*pending
+	order   *list.List // of *pending, oldest first
+	decided map[trace.TraceID]bool
+	recent  *list.List // of trace.TraceID, the decided ones, oldest first
+
+	traces      *prometheus.CounterVec
+	droppedSpan *prometheus.CounterVec
+	buffered    prometheus.GaugeFunc
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type pending struct {
+	id    trace.TraceID
+	start time.Time
+	spans []*trace.SpanData
+	elem  *list.Element
+
+	keep   bool // the decision, once removed from the buffer
+	reason string
+}
+
+var _ trace.Exporter = (*Exporter)(nil)
+
+// New Exporter exporting the kept traces to next. Call Close to stop it.
+func New(next trace.Exporter, opts Options) *Exporter {
+	if opts.Window <= 0 {
+		opts.Window = DefaultOptions.Window
+	}
+	if opts.Latency <= 0 {
+		opts.Latency = DefaultOptions.Latency
+	}
+	if opts.Rate < 0 {
+		opts.Rate = DefaultOptions.Rate
+	}
+	if opts.MaxTraces <= 0 {
+		opts.MaxTraces = DefaultOptions.MaxTraces
+	}
+	if opts.MaxSpansPerTrace <= 0 {
+		opts.MaxSpansPerTrace = DefaultOptions.MaxSpansPerTrace
+	}
+
+	e := &Exporter{
+		next:    next,
+		opts:    opts,
+		pending: make(map[trace.TraceID]*pending),
+		order:   list.New(),
+		decided: make(map[trace.TraceID]bool),
+		recent:  list.New(),
+		traces: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trace_tail_sampling_traces_total",
+			Help: "Traces decided by tail sampling, by decision (kept, dropped) and reason.",
+		}, []string{"decision", "reason"}),
+		droppedSpan: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trace_tail_sampling_dropped_spans_total",
+			Help: "Spans dropped by tail sampling without being part of a decision, by reason (trace_full, late).",
+		}, []string{"reason"}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	e.buffered = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "trace_tail_sampling_buffered_traces",
+		Help: "Traces currently buffered by tail sampling.",
+	}, func() float64 {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return float64(len(e.pending))
+	})
+	for _, d := range []string{"kept", "dropped"} {
+		for _, r := range []string{ReasonError, ReasonSlow, ReasonSampled, ReasonUnsampled} {
+			if (d == "kept") != (r == ReasonUnsampled) {
+				e.traces.WithLabelValues(d, r)
+			}
+		}
+	}
+
+	go e.run()
+	return e
+}
+
+// ExportSpan implements trace.Exporter.
+func (e *Exporter) ExportSpan(sd *trace.SpanData) {
+	e.mu.Lock()
+
+	if keep, ok := e.decided[sd.TraceID]; ok { // a straggler, follow the decision
+		e.mu.Unlock()
+		if keep {
+			e.next.ExportSpan(sd)
+		} else {
+			e.droppedSpan.WithLabelValues("late").Inc()
+		}
+		return
+	}
+
+	var evicted *pending
+	p, ok := e.pending[sd.TraceID]
+	if !ok {
+		if len(e.pending) >= e.opts.MaxTraces {
+			evicted = e.remove(e.order.Front().Value.(*pending))
+		}
+		p = &pending{id: sd.TraceID, start: time.Now()}
+		p.elem = e.order.PushBack(p)
+		e.pending[sd.TraceID] = p
+	}
+
+	var ready *pending
+	if len(p.spans) < e.opts.MaxSpansPerTrace {
+		p.spans = append(p.spans, sd)
+	} else {
+		e.droppedSpan.WithLabelValues("trace_full").Inc()
+	}
+	if localRoot(sd) { // the local root ends last, the trace is complete
+		ready = e.remove(p)
+	}
+	e.mu.Unlock()
+
+	if evicted != nil {
+		e.decide(evicted)
+	}
+	if ready != nil {
+		e.decide(ready)
+	}
+}
+
+// remove p from the buffer and decide whether to keep it, remembering the
+// decision for stragglers. e.mu must be held.
+func (e *Exporter) remove(p *pending) *pending {
+	delete(e.pending, p.id)
+	e.order.Remove(p.elem)
+	p.keep, p.reason = e.keep(p)
+	e.decided[p.id] = p.keep
+	e.recent.PushBack(p.id)
+	for e.recent.Len() > e.opts.MaxTraces {
+		delete(e.decided, e.recent.Remove(e.recent.Front()).(trace.TraceID))
+	}
+	return p
+}
+
+// decide records the decision made for p, exporting its spans if it's kept.
+func (e *Exporter) decide(p *pending) {
+	if !p.keep {
+		e.traces.WithLabelValues("dropped", p.reason).Inc()
+		return
+	}
+	e.traces.WithLabelValues("kept", p.reason).Inc()
+	for _, sd := range p.spans {
+		e.next.ExportSpan(sd)
+	}
+}
+
+func (e *Exporter) keep(p *pending) (bool, string) {
+	var root *trace.SpanData
+	var slowest time.Duration
+	for _, sd := range p.spans {
+		if sd.Code != trace.StatusCodeOK {
+			return true, ReasonError
+		}
+		if localRoot(sd) {
+			root = sd
+		}
+		if d := sd.EndTime.Sub(sd.StartTime); d > slowest {
+			slowest = d
+		}
+	}
+	if root != nil { // without the root, go by the slowest span
+		slowest = root.EndTime.Sub(root.StartTime)
+	}
+	if slowest > e.opts.Latency {
+		return true, ReasonSlow
+	}
+
+	if e.opts.Rate > 0 {
+		x := binary.BigEndian.Uint64(p.id[0:8]) >> 1
+		if e.opts.Rate >= 1 || x < uint64(e.opts.Rate*(1<<63)) {
+			return true, ReasonSampled
+		}
+	}
+	return false, ReasonUnsampled
+}
+
+// localRoot reports whether sd is the root of its trace in this process.
+func localRoot(sd *trace.SpanData) bool {
+	return sd.ParentSpanID == (trace.SpanID{}) || sd.HasRemoteParent
+}
+
+// run decides the traces that have been buffered for longer than the window.
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	tick := e.opts.Window / 10
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case now := <-t.C:
+			var expired []*pending
+			e.mu.Lock()
+			for f := e.order.Front(); f != nil; f = e.order.Front() {
+				p := f.Value.(*pending)
+				if now.Sub(p.start) < e.opts.Window {
+					break
+				}
+				expired = append(expired, e.remove(p))
+			}
+			e.mu.Unlock()
+
+			for _, p := range expired {
+				e.decide(p)
+			}
+		}
+	}
+}
+
+// Close stops e, deciding all the buffered traces.
+func (e *Exporter) Close() {
+	close(e.stop)
+	<-e.done
+
+	var all []*pending
+	e.mu.Lock()
+	for f := e.order.Front(); f != nil; f = e.order.Front() {
+		all = append(all, e.remove(f.Value.(*pending)))
+	}
+	e.mu.Unlock()
+
+	for _, p := range all {
+		e.decide(p)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.traces.Describe(ch)
+	e.droppedSpan.Describe(ch)
+	e.buffered.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.traces.Collect(ch)
+	e.droppedSpan.Collect(ch)
+	e.buffered.Collect(ch)
+}
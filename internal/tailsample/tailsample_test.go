@@ -0,0 +1,182 @@
+package tailsample
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opencensus.io/trace"
+)
+
+// recorder is a trace.Exporter recording the spans exported to it.
+type recorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *recorder) ExportSpan(sd *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, sd)
+}
+
+func (r *recorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.spans)
+}
+
+var (
+	low  = trace.TraceID{0x00, 1} // kept at any Rate > 0
+	high = trace.TraceID{0xff, 1} // only kept at Rate 1
+	mid  = trace.TraceID{0x40, 1} // kept at Rate > 0.25
+)
+
+// span returns a span of trace id lasting d, a local root when parent is 0.
+func span(id trace.TraceID, sid, parent byte, d time.Duration, code int32) *trace.SpanData {
+	start := time.Unix(1e9, 0)
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: id, SpanID: trace.SpanID{sid}},
+		StartTime:   start,
+		EndTime:     start.Add(d),
+		Status:      trace.Status{Code: code},
+	}
+	if parent != 0 {
+		sd.ParentSpanID = trace.SpanID{parent}
+	}
+	return sd
+}
+
+func TestKeep(t *testing.T) {
+	const ms = time.Millisecond
+	for _, tc := range []struct {
+		name   string
+		rate   float64
+		spans  []*trace.SpanData // the local root, if any, last
+		keep   bool
+		reason string
+	}{
+		{"fast", DefaultOptions.Rate, []*trace.SpanData{span(high, 2, 1, ms, 0), span(high, 1, 0, 10*ms, 0)}, false, ReasonUnsampled},
+		{"error in a child", DefaultOptions.Rate, []*trace.SpanData{span(high, 2, 1, ms, trace.StatusCodeInternal), span(high, 1, 0, 10*ms, 0)}, true, ReasonError},
+		{"error in the root", DefaultOptions.Rate, []*trace.SpanData{span(high, 1, 0, ms, trace.StatusCodeNotFound)}, true, ReasonError},
+		{"slow root", DefaultOptions.Rate, []*trace.SpanData{span(high, 1, 0, 300*ms, 0)}, true, ReasonSlow},
+		{"root at the latency", DefaultOptions.Rate, []*trace.SpanData{span(high, 1, 0, 250*ms, 0)}, false, ReasonUnsampled},
+		{"remote parent is a local root", DefaultOptions.Rate, []*trace.SpanData{func() *trace.SpanData {
+			sd := span(high, 1, 9, 300*ms, 0)
+			sd.HasRemoteParent = true
+			return sd
+		}()}, true, ReasonSlow},
+		{"sampled", DefaultOptions.Rate, []*trace.SpanData{span(low, 1, 0, ms, 0)}, true, ReasonSampled},
+		{"rate 0", 0, []*trace.SpanData{span(low, 1, 0, ms, 0)}, false, ReasonUnsampled},
+		{"rate 0 still keeps errors", 0, []*trace.SpanData{span(low, 1, 0, ms, trace.StatusCodeUnknown)}, true, ReasonError},
+		{"rate below the id", .2, []*trace.SpanData{span(mid, 1, 0, ms, 0)}, false, ReasonUnsampled},
+		{"rate above the id", .3, []*trace.SpanData{span(mid, 1, 0, ms, 0)}, true, ReasonSampled},
+		{"rate 1", 1, []*trace.SpanData{span(high, 1, 0, ms, 0)}, true, ReasonSampled},
+		{"negative rate is the default", -1, []*trace.SpanData{span(low, 1, 0, ms, 0)}, true, ReasonSampled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r recorder
+			e := New(&r, Options{Rate: tc.rate})
+			defer e.Close()
+			for _, sd := range tc.spans {
+				e.ExportSpan(sd)
+			}
+
+			want := 0
+			decision := "dropped"
+			if tc.keep {
+				want = len(tc.spans)
+				decision = "kept"
+			}
+			if n := r.len(); n != want {
+				t.Errorf("%d spans exported, want %d", n, want)
+			}
+			if n := testutil.ToFloat64(e.traces.WithLabelValues(decision, tc.reason)); n != 1 {
+				t.Errorf("%d traces %s for %s, want 1", int(n), decision, tc.reason)
+			}
+		})
+	}
+}
+
+func TestLateSpans(t *testing.T) {
+	var r recorder
+	e := New(&r, Options{Rate: DefaultOptions.Rate})
+	defer e.Close()
+
+	e.ExportSpan(span(low, 1, 0, time.Millisecond, 0)) // kept, sampled
+	e.ExportSpan(span(high, 1, 0, time.Millisecond, 0))
+	e.ExportSpan(span(low, 2, 1, time.Millisecond, 0))
+	e.ExportSpan(span(high, 2, 1, time.Millisecond, 0))
+
+	if n := r.len(); n != 2 {
+		t.Errorf("%d spans exported, want 2", n)
+	}
+	if n := testutil.ToFloat64(e.droppedSpan.WithLabelValues("late")); n != 1 {
+		t.Errorf("%v late spans dropped, want 1", n)
+	}
+}
+
+func TestMaxSpansPerTrace(t *testing.T) {
+	var r recorder
+	e := New(&r, Options{Rate: 1, MaxSpansPerTrace: 2})
+	defer e.Close()
+
+	for i := byte(2); i < 5; i++ {
+		e.ExportSpan(span(low, i, 1, time.Millisecond, 0))
+	}
+	e.ExportSpan(span(low, 1, 0, time.Millisecond, 0))
+
+	if n := r.len(); n != 2 {
+		t.Errorf("%d spans exported, want 2", n)
+	}
+	if n := testutil.ToFloat64(e.droppedSpan.WithLabelValues("trace_full")); n != 2 {
+		t.Errorf("%v spans dropped for a full trace, want 2", n)
+	}
+}
+
+func TestMaxTraces(t *testing.T) {
+	var r recorder
+	e := New(&r, Options{Rate: 1, MaxTraces: 2})
+	defer e.Close()
+
+	for i := byte(1); i <= 3; i++ {
+		e.ExportSpan(span(trace.TraceID{i}, 2, 1, time.Millisecond, 0)) // no roots
+	}
+	if n := r.len(); n != 1 {
+		t.Fatalf("%d spans exported, want the oldest trace's 1", n)
+	}
+	if id := r.spans[0].TraceID; id != (trace.TraceID{1}) {
+		t.Errorf("trace %v exported, want the oldest one", id)
+	}
+	if n := testutil.ToFloat64(e.buffered); n != 2 {
+		t.Errorf("%v traces buffered, want 2", n)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	var r recorder
+	e := New(&r, Options{Rate: 1, Window: time.Millisecond})
+	defer e.Close()
+
+	e.ExportSpan(span(low, 2, 1, time.Millisecond, 0)) // the root never ends
+	for deadline := time.Now().Add(5 * time.Second); r.len() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("trace not decided after its window")
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	var r recorder
+	e := New(&r, Options{Rate: 1})
+	e.ExportSpan(span(low, 2, 1, time.Millisecond, 0))
+	e.ExportSpan(span(high, 2, 1, time.Millisecond, 0))
+	if n := r.len(); n != 0 {
+		t.Fatalf("%d spans exported before Close, want 0", n)
+	}
+	e.Close()
+	if n := r.len(); n != 2 {
+		t.Errorf("%d spans exported by Close, want 2", n)
+	}
+}
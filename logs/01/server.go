@@ -1,18 +1,21 @@
 package main
 
 import (
-	"log"
+	stdlog "log"
 	"math/rand"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/freeformz/goobser/internal/levellog"
 	"github.com/pkg/errors"
 )
 
+var log = levellog.New(stdlog.New(os.Stderr, "", stdlog.LstdFlags|stdlog.Lshortfile), levellog.Info)
+
 func work() error { // pretend work
 	defer func(t time.Time) {
-		log.Printf("Work took %2.3fs\n", time.Since(t).Seconds())
+		log.Debugf("Work took %2.3fs\n", time.Since(t).Seconds())
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -28,13 +31,13 @@ func work() error { // pretend work
 func handler(w http.ResponseWriter, r *http.Request) {
 	status := http.StatusOK // net/http returns 200 by default
 	defer func(t time.Time) {
-		log.Printf("%s %q => %d (%2.3fs)\n", r.Method, r.URL.String(), status, time.Since(t).Seconds())
+		log.Infof("%s %q => %d (%2.3fs)\n", r.Method, r.URL.String(), status, time.Since(t).Seconds())
 	}(time.Now())
 
 	if err := work(); err != nil {
 		status = http.StatusBadRequest
 		http.Error(w, ":-(", status)
-		log.Println("Error:", err.Error())
+		log.Error("Error:", err.Error())
 		return
 	}
 
@@ -42,7 +45,13 @@ func handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	log.SetFlags(log.Flags() | log.Lshortfile)
+	if v := os.Getenv("LOG_LEVEL"); v != "" { // debug, info, warn or error
+		lvl, err := levellog.ParseLevel(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.SetLevel(lvl)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -51,7 +60,7 @@ func main() {
 
 	http.HandleFunc("/", handler)
 
-	log.Println("Listening at: http://localhost:" + port)
+	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
 	}
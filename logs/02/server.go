@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/freeformz/goobser/internal/logging"
+	"github.com/freeformz/goobser/internal/loglevel"
 	"github.com/sirupsen/logrus"
 )
 
-func work(log logrus.FieldLogger) error { // pretend work
+func work(ctx context.Context) error { // pretend work
+	log := logging.FromContext(ctx).WithField(loglevel.ComponentKey, "work")
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -25,26 +29,14 @@ func work(log logrus.FieldLogger) error { // pretend work
 	return err
 }
 
-func httpLogginghandler(log logrus.FieldLogger) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		status := http.StatusOK // net/http returns 200 by default
-		log = log.WithFields(logrus.Fields{
-			"method": r.Method,
-			"path":   r.URL.String(),
-		})
-		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
-		}(time.Now())
-
-		if err := work(log); err != nil {
-			status = http.StatusBadRequest
-			http.Error(w, "Nope", status)
-			log.Error("OMG Error!")
-			return
-		}
-
-		w.Write([]byte(`:-)`))
+func httpLogginghandler(w http.ResponseWriter, r *http.Request) {
+	if err := work(r.Context()); err != nil {
+		http.Error(w, "Nope", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Error("OMG Error!") // request scoped, see logging.Tail
+		return
 	}
+
+	w.Write([]byte(`:-)`))
 }
 
 func main() {
@@ -52,9 +44,11 @@ func main() {
 		DisableColors: true,
 	})*/
 
-	logrus.SetFormatter(&logrus.JSONFormatter{
-		DisableColors: true,
-	})
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+
+	// allow the log level to be changed at runtime via /debug/loglevel,
+	// either globally or for just the "work" or "access" components
+	levels := loglevel.New(logrus.StandardLogger())
 
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
@@ -64,7 +58,23 @@ func main() {
 		port = "8080"
 	}
 
-	http.HandleFunc("/", httpLogginghandler(log))
+	// Only emit the work logs for failed or slow (> 90ms, work takes 1..100ms)
+	// requests, everything else just gets an access line.
+	http.Handle("/", logging.Tail(
+		log,
+		logging.TailOptions{Latency: 90 * time.Millisecond},
+		http.HandlerFunc(httpLogginghandler),
+	))
+
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
 
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
@@ -8,12 +8,13 @@ import (
 	"os"
 	"time"
 
+	"github.com/freeformz/goobser/internal/loglevel"
 	"github.com/sirupsen/logrus"
 )
 
 func work(log logrus.FieldLogger) error { // pretend work
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -34,7 +35,7 @@ func httpLoggingHandler(log logrus.FieldLogger) http.HandlerFunc {
 			"path":   r.URL.String(),
 		})
 		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		if err := work(log); err != nil {
@@ -53,6 +54,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
 
@@ -67,6 +71,16 @@ func main() {
 
 	http.HandleFunc("/", httpLoggingHandler(log))
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
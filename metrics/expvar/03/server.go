@@ -2,43 +2,20 @@ package main
 
 import (
 	"expvar"
-	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
-	"sync"
 	"time"
 
+	"github.com/freeformz/goobser/internal/expvarx"
+	"github.com/freeformz/goobser/internal/loglevel"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-type myTimer struct {
-	mu    sync.RWMutex
-	count int
-	sum   time.Duration
-}
-
-func (v *myTimer) Finish(t time.Time) {
-	v.mu.Lock()
-	defer v.mu.Unlock()
-	v.sum += time.Since(t)
-	v.count++
-}
-
-func (v *myTimer) String() string {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-	var avg int
-	if v.count != 0 { // avoid divide by zero
-		avg = int(v.sum) / v.count
-	}
-	return fmt.Sprintf(`{"Count": %d, "Sum": %d, "Avg": %d}`, v.count, v.sum, avg)
-}
-
 func work(log logrus.FieldLogger) error { // pretend work
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -51,22 +28,23 @@ func work(log logrus.FieldLogger) error { // pretend work
 	return err
 }
 
-func timerMiddleware(t *myTimer, hf http.HandlerFunc) http.HandlerFunc {
+func timerMiddleware(t *expvarx.Timer, hf http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer t.Finish(time.Now())
 		hf(w, r)
 	}
 }
 
-func httpLoggingAndMetricsHandler(log logrus.FieldLogger, errs *expvar.Int) http.HandlerFunc {
+func httpLoggingAndMetricsHandler(log logrus.FieldLogger, rate *expvarx.Meter, errs *expvar.Int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		rate.Mark(1)
 		status := http.StatusOK // net/http returns 200 by default
 		log = log.WithFields(logrus.Fields{
 			"method": r.Method,
 			"path":   r.URL.String(),
 		})
 		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		if err := work(log); err != nil {
@@ -86,6 +64,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
 
@@ -101,14 +82,30 @@ func main() {
 	// Export the numbers
 	errs := expvar.NewInt("Errors")
 
-	var t myTimer
-	expvar.Publish("Requests", &t)
+	// Requests per second over the last 1, 5 & 15 minutes
+	rate := expvarx.NewMeter()
+	expvar.Publish("RequestRate", rate)
+
+	// Count, Sum & Avg, plus min, max & percentiles over the lifetime of the
+	// program and the last minute
+	t := expvarx.NewTimer(time.Minute)
+	expvar.Publish("Requests", t)
 
 	http.HandleFunc("/", timerMiddleware(
-		&t,
-		httpLoggingAndMetricsHandler(log, errs),
+		t,
+		httpLoggingAndMetricsHandler(log, rate, errs),
 	))
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
@@ -9,7 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/freeformz/goobser/internal/expvarprom"
+	"github.com/freeformz/goobser/internal/loglevel"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
@@ -39,7 +42,7 @@ func (v *myTimer) String() string {
 
 func work(log logrus.FieldLogger) error { // pretend work
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -67,7 +70,7 @@ func httpLoggingAndMetricsHandler(log logrus.FieldLogger, errs *expvar.Int) http
 			"path":   r.URL.String(),
 		})
 		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		if err := work(log); err != nil {
@@ -87,6 +90,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
 
@@ -97,6 +103,29 @@ func main() {
 
 	http.Handle("/metrics", promhttp.Handler())
 
+	// Bridge the expvar metrics below to prometheus, so they're available
+	// from /metrics as well as /debug/vars
+	prometheus.MustRegister(&expvarprom.Collector{
+		Mappings: map[string]expvarprom.Mapping{
+			"Requests.Count": {
+				Name: "http_requests_total",
+				Help: "Total http requests.",
+				Type: prometheus.CounterValue,
+			},
+			"Requests.Sum": {
+				Name:  "http_request_duration_seconds_total",
+				Help:  "Total time spent handling http requests.",
+				Type:  prometheus.CounterValue,
+				Scale: 1e-9, // ns => s
+			},
+			"Errors": {
+				Name: "http_errors_total",
+				Help: "Total http errors.",
+				Type: prometheus.CounterValue,
+			},
+		},
+	})
+
 	// Expose the port value
 	ep := expvar.NewString("Port")
 	ep.Set(port)
@@ -112,6 +141,16 @@ func main() {
 		httpLoggingAndMetricsHandler(log, errs),
 	))
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
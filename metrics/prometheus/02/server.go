@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/freeformz/goobser/internal/loglevel"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,7 +16,7 @@ import (
 
 func work(log logrus.FieldLogger) error { // pretend work
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -37,7 +38,7 @@ func httpLoggingAndMetricsHandler(log logrus.FieldLogger, reqs, errs prometheus.
 		})
 		defer func(t time.Time) {
 			reqs.Add(1)
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		if err := work(log); err != nil {
@@ -57,6 +58,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
 
@@ -85,6 +89,16 @@ func main() {
 
 	http.HandleFunc("/", httpLoggingAndMetricsHandler(log, reqs, errs))
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"expvar"
 	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/freeformz/goobser/internal/buildinfo"
+	"github.com/freeformz/goobser/internal/loglevel"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,7 +18,7 @@ import (
 
 func work(log logrus.FieldLogger) error { // pretend work
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -38,7 +41,7 @@ func httpLoggingAndMetricsHandler(log logrus.FieldLogger, durs prometheus.Observ
 		defer func(t time.Time) {
 			secs := time.Since(t).Seconds()
 			durs.WithLabelValues(strconv.Itoa(status)).Observe(secs)
-			log.WithField("status", status).WithField("duration", secs).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", secs).Info()
 		}(time.Now())
 
 		if err := work(log); err != nil {
@@ -57,6 +60,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
 
@@ -67,15 +73,13 @@ func main() {
 
 	http.Handle("/metrics", promhttp.Handler())
 
-	// Expose the port value
-	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "program_info",
-		Help: "Info about the program.",
-	},
-		[]string{"port"},
-	)
+	// Expose build & runtime info, including the effective configuration
+	info, err := buildinfo.New(map[string]string{"port": port})
+	if err != nil {
+		log.Fatal(err)
+	}
 	prometheus.MustRegister(info)
-	info.WithLabelValues(port).Set(1)
+	expvar.Publish("BuildInfo", info)
 
 	durs := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -92,6 +96,16 @@ func main() {
 
 	http.HandleFunc("/", httpLoggingAndMetricsHandler(log, durs))
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
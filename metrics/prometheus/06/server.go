@@ -1,12 +1,15 @@
 package main
 
 import (
+	"expvar"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
 	"time"
 
+	"github.com/freeformz/goobser/internal/buildinfo"
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/freeformz/goobser/internal/promroute"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,7 +18,7 @@ import (
 
 func work(log logrus.FieldLogger) error { // pretend work
 	defer func(t time.Time) {
-		log.WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
+		log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", time.Since(t).Seconds()).Info("Work complete")
 	}(time.Now())
 
 	s := rand.Intn(99) + 1 // 1..100
@@ -30,7 +33,7 @@ func work(log logrus.FieldLogger) error { // pretend work
 
 func slowWork(log logrus.FieldLogger) error { // slow pretend work
 	s := 100 + rand.Intn(200) // 100..300
-	defer log.WithField("work_seconds", float64(s)/1000).Info("Work complete")
+	defer log.WithField(loglevel.ComponentKey, "work").WithField("work_seconds", float64(s)/1000).Info("Work complete")
 
 	time.Sleep(time.Duration(s) * time.Millisecond)
 
@@ -47,7 +50,7 @@ func httpLoggingAndMetricsHandler(log logrus.FieldLogger, wf workFunc) http.Hand
 			"path":   r.URL.String(),
 		})
 		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		if err := wf(log); err != nil {
@@ -66,6 +69,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "logs-02-server")
 
@@ -76,15 +82,13 @@ func main() {
 
 	http.Handle("/metrics", promhttp.Handler())
 
-	// Expose the port value
-	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "program_info",
-		Help: "Info about the program.",
-	},
-		[]string{"port"},
-	)
+	// Expose build & runtime info, including the effective configuration
+	info, err := buildinfo.New(map[string]string{"port": port})
+	if err != nil {
+		log.Fatal(err)
+	}
 	prometheus.MustRegister(info)
-	info.WithLabelValues(port).Set(1)
+	expvar.Publish("BuildInfo", info)
 
 	durs := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -96,21 +100,38 @@ func main() {
 		[]string{"handler", "code"},
 	)
 	prometheus.MustRegister(durs)
-	durs.WithLabelValues("regularWork", strconv.Itoa(http.StatusOK))
-	durs.WithLabelValues("regularWork", strconv.Itoa(http.StatusBadRequest))
-	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusOK))
-	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusBadRequest))
 
-	http.HandleFunc("/", promhttp.InstrumentHandlerDuration(
-		durs.MustCurryWith(prometheus.Labels{"handler": "regularWork"}),
+	// register the routes, initializing the durs series of each handler for
+	// the status codes it's expected to return
+	routes := &promroute.Table{
+		Codes: []int{http.StatusOK, http.StatusBadRequest},
+		Vecs:  []prometheus.ObserverVec{durs},
+	}
+
+	routes.HandleFunc("/", "regularWork", promhttp.InstrumentHandlerDuration(
+		routes.Curry(durs, "regularWork"),
 		httpLoggingAndMetricsHandler(log, work),
 	))
 
-	http.HandleFunc("/slow", promhttp.InstrumentHandlerDuration(
-		durs.MustCurryWith(prometheus.Labels{"handler": "slowWork"}),
+	routes.HandleFunc("/slow", "slowWork", promhttp.InstrumentHandlerDuration(
+		routes.Curry(durs, "slowWork"),
 		httpLoggingAndMetricsHandler(log, slowWork),
 	))
 
+	if err := routes.Check(); err != nil {
+		log.Fatal(err)
+	}
+
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatal("Errored with: " + err.Error())
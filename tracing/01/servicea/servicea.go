@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"io"
 	"math/rand"
 	"net/http"
@@ -8,7 +9,10 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/freeformz/goobser/internal/buildinfo"
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/freeformz/goobser/internal/promclient"
+	"github.com/freeformz/goobser/internal/requestid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -22,18 +26,14 @@ func errorResponse(log logrus.FieldLogger, err error, w http.ResponseWriter, sta
 
 func queryServiceBHandler(c *http.Client, log logrus.FieldLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.Header.Get("X-Request-ID")
-		if id == "" {
-			id = uuid.New().String()
-		}
-		log = log.WithFields(logrus.Fields{
+		log := log.WithFields(logrus.Fields{
 			"method":     r.Method,
 			"path":       r.URL.String(),
-			"request_id": id,
+			"request_id": requestid.FromContext(r.Context()),
 		})
 		status := http.StatusOK // net/http returns 200 by default
 		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		s := rand.Intn(99) + 1 // 1..100
@@ -52,9 +52,8 @@ func queryServiceBHandler(c *http.Client, log logrus.FieldLogger) http.HandlerFu
 			errorResponse(log, errors.Wrap(err, "creating request"), w, status)
 			return
 		}
-		req.Header.Set("X-Request-ID", id)
 
-		resp, err := c.Do(req)
+		resp, err := c.Do(req.WithContext(r.Context())) // requestid.Transport sets the X-Request-ID header
 		if err != nil {
 			status = http.StatusInternalServerError
 			errorResponse(log, errors.Wrap(err, "doing request"), w, status)
@@ -64,7 +63,7 @@ func queryServiceBHandler(c *http.Client, log logrus.FieldLogger) http.HandlerFu
 
 		if resp.Body != nil {
 			b, err := io.Copy(w, resp.Body)
-			log.WithField("proxied_bytes", b).Info()
+			log.WithField(loglevel.ComponentKey, "proxy").WithField("proxied_bytes", b).Info()
 			if err != nil {
 				status = http.StatusInternalServerError
 				if b == 0 {
@@ -82,17 +81,13 @@ func queryServiceBHandler(c *http.Client, log logrus.FieldLogger) http.HandlerFu
 
 func slowHandler(log logrus.FieldLogger) http.HandlerFunc { // slow pretend work
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.Header.Get("X-Request-ID")
-		if id == "" {
-			id = uuid.New().String()
-		}
-		log = log.WithFields(logrus.Fields{
+		log := log.WithFields(logrus.Fields{
 			"method":     r.Method,
 			"path":       r.URL.String(),
-			"request_id": id,
+			"request_id": requestid.FromContext(r.Context()),
 		})
 		defer func(t time.Time) {
-			log.WithField("status", http.StatusOK).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", http.StatusOK).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		s := 100 + rand.Intn(200) // 100..300
@@ -107,6 +102,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "servicea")
 
@@ -117,15 +115,13 @@ func main() {
 
 	http.Handle("/metrics", promhttp.Handler())
 
-	// Expose the port value
-	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "program_info",
-		Help: "Info about the program.",
-	},
-		[]string{"port"},
-	)
+	// Expose build & runtime info, including the effective configuration
+	info, err := buildinfo.New(map[string]string{"port": port})
+	if err != nil {
+		log.Fatal(err)
+	}
 	prometheus.MustRegister(info)
-	info.WithLabelValues(port).Set(1)
+	expvar.Publish("BuildInfo", info)
 
 	durs := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -142,8 +138,17 @@ func main() {
 	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusOK))
 	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusBadRequest))
 
+	// instrument the calls made to serviceb
+	cm := promclient.NewMetrics(nil)
+	prometheus.MustRegister(cm)
+
 	var c http.Client
 	c.Timeout = 2 * time.Second // always set sensible values for your service, never trust the defaults
+	c.Transport = &promclient.Transport{
+		Base:    &requestid.Transport{},
+		Service: "serviceb",
+		Metrics: cm,
+	}
 	http.HandleFunc("/", promhttp.InstrumentHandlerDuration(
 		durs.MustCurryWith(prometheus.Labels{"handler": "regularWork"}),
 		http.HandlerFunc(queryServiceBHandler(&c, log)),
@@ -154,8 +159,19 @@ func main() {
 		http.HandlerFunc(slowHandler(log)),
 	))
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	// read or generate the X-Request-ID of every request
+	if err := http.ListenAndServe(":"+port, &requestid.Handler{Handler: http.DefaultServeMux}); err != nil {
 		log.Fatal("Errored with: " + err.Error())
 	}
 }
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"expvar"
 	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/freeformz/goobser/internal/buildinfo"
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/freeformz/goobser/internal/requestid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
@@ -14,15 +18,14 @@ import (
 
 func workHandler(log logrus.FieldLogger) http.HandlerFunc { // pretend work
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.Header.Get("X-Request-ID")
-		log = log.WithFields(logrus.Fields{
+		log := log.WithFields(logrus.Fields{
 			"method":     r.Method,
 			"path":       r.URL.String(),
-			"request_id": id,
+			"request_id": requestid.FromContext(r.Context()),
 		})
 		status := http.StatusOK // net/http returns 200 by default
 		defer func(t time.Time) {
-			log.WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", status).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		s := rand.Intn(99) + 1 // 1..100
@@ -43,14 +46,13 @@ func workHandler(log logrus.FieldLogger) http.HandlerFunc { // pretend work
 
 func slowWorkHandler(log logrus.FieldLogger) http.HandlerFunc { // slow pretend work
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.Header.Get("X-Request-ID")
-		log = log.WithFields(logrus.Fields{
+		log := log.WithFields(logrus.Fields{
 			"method":     r.Method,
 			"path":       r.URL.String(),
-			"request_id": id,
+			"request_id": requestid.FromContext(r.Context()),
 		})
 		defer func(t time.Time) {
-			log.WithField("status", http.StatusOK).WithField("duration", time.Since(t).Seconds()).Info()
+			log.WithField(loglevel.ComponentKey, "access").WithField("status", http.StatusOK).WithField("duration", time.Since(t).Seconds()).Info()
 		}(time.Now())
 
 		s := 100 + rand.Intn(200) // 100..300
@@ -66,6 +68,9 @@ func main() {
 		DisableColors: true,
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "serviceb")
 
@@ -76,15 +81,13 @@ func main() {
 
 	http.Handle("/metrics", promhttp.Handler())
 
-	// Expose the port value
-	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "program_info",
-		Help: "Info about the program.",
-	},
-		[]string{"port"},
-	)
+	// Expose build & runtime info, including the effective configuration
+	info, err := buildinfo.New(map[string]string{"port": port})
+	if err != nil {
+		log.Fatal(err)
+	}
 	prometheus.MustRegister(info)
-	info.WithLabelValues(port).Set(1)
+	expvar.Publish("BuildInfo", info)
 
 	durs := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -115,8 +118,19 @@ func main() {
 		),
 	)
 
+	// /debug/loglevel isn't authenticated, so serve it on the ADMIN_ADDR
+	// address rather than on the public port
+	adminAddr, err := levels.ServeAdmin("localhost:9081")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if adminAddr != "" {
+		log.Info("Log levels at: http://" + adminAddr + "/debug/loglevel")
+	}
+
 	log.Info("Listening at: http://localhost:" + port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	// read or generate the X-Request-ID of every request
+	if err := http.ListenAndServe(":"+port, &requestid.Handler{Handler: http.DefaultServeMux}); err != nil {
 		log.Fatal("Errored with: " + err.Error())
 	}
 }
@@ -1,29 +1,49 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"flag"
 	"io"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/jaeger"
+	ocprom "contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/freeformz/goobser/internal/baggage"
+	"github.com/freeformz/goobser/internal/buildinfo"
+	"github.com/freeformz/goobser/internal/logging"
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/freeformz/goobser/internal/promclient"
+	"github.com/freeformz/goobser/internal/promroute"
+	"github.com/freeformz/goobser/internal/propagation"
+	"github.com/freeformz/goobser/internal/red"
+	"github.com/freeformz/goobser/internal/sampling"
+	"github.com/freeformz/goobser/internal/semconv"
+	"github.com/freeformz/goobser/internal/spanfile"
+	"github.com/freeformz/goobser/internal/tailsample"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ochttp"
-	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
+	"go.opencensus.io/zpages"
 )
 
 const (
 	serviceBURL = "http://localhost:8081"
 )
 
-func errorResponse(span *trace.Span, err error, w http.ResponseWriter) {
-	span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: err.Error()})
+func errorResponse(ctx context.Context, span *trace.Span, err error, w http.ResponseWriter) {
+	logging.FromContext(ctx).Error(err.Error())
+	semconv.Response(span, http.StatusInternalServerError)
+	span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: err.Error()}) // with the error as message
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
@@ -32,34 +52,31 @@ func queryServiceBHandler(c *http.Client, url string) http.HandlerFunc {
 		url := url // make a copy
 		ctx, span := trace.StartSpan(r.Context(), "queryServiceBHandler")
 		defer span.End()
+		semconv.Server(span, r, "/")
 
 		s := rand.Intn(99) + 1 // 1..100
-		span.Annotate([]trace.Attribute{
-			trace.Int64Attribute("s", int64(s)),
-		}, "")
+		span.AddAttributes(trace.Int64Attribute("s", int64(s)))
 
 		// Pretend local computation before calling service b
 		time.Sleep(time.Duration(s) * time.Millisecond / 4)
-		span.SetStatus(trace.Status{Message: "local work complete"})
+		span.Annotate(nil, "local work complete")
 
 		if s <= 25 { // ~25% of the time call b's slow URL
 			url = url + "/slow"
 		}
-		span.Annotate([]trace.Attribute{
-			trace.StringAttribute("url", url),
-		}, "")
 
 		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
-			errorResponse(span, errors.Wrap(err, "creating request"), w)
+			errorResponse(ctx, span, errors.Wrap(err, "creating request"), w)
 			return
 		}
 
 		resp, err := c.Do(req.WithContext(ctx))
 		if err != nil {
-			errorResponse(span, errors.Wrap(err, "doing request"), w)
+			errorResponse(ctx, span, errors.Wrap(err, "doing request"), w)
 			return
 		}
+		semconv.Response(span, resp.StatusCode) // we respond with serviceb's status
 
 		w.WriteHeader(resp.StatusCode)
 
@@ -70,7 +87,7 @@ func queryServiceBHandler(c *http.Client, url string) http.HandlerFunc {
 			}, "proxied")
 			if err != nil {
 				if b == 0 {
-					errorResponse(span, errors.Wrap(err, "proxying bytes"), w)
+					errorResponse(ctx, span, errors.Wrap(err, "proxying bytes"), w)
 				}
 				return
 			}
@@ -85,22 +102,29 @@ func queryServiceBHandler(c *http.Client, url string) http.HandlerFunc {
 func slowLocalWork(w http.ResponseWriter, r *http.Request) { // slow pretend work
 	_, span := trace.StartSpan(r.Context(), "slowLocalWork")
 	defer span.End()
+	semconv.Server(span, r, "/slow")
 
 	s := 100 + rand.Intn(200) // 100..300
-	span.Annotate([]trace.Attribute{
-		trace.Int64Attribute("s", int64(s)),
-	}, "")
+	span.AddAttributes(trace.Int64Attribute("s", int64(s)))
 
 	time.Sleep(time.Duration(s) * time.Millisecond)
 
+	semconv.Response(span, http.StatusOK)
 	w.Write([]byte(`a = 🐢 `))
 }
 
 func main() {
-	logrus.SetFormatter(&logrus.TextFormatter{
-		DisableColors: true,
+	// add the trace_id, span_id & sampled fields of the current span to logs
+	logrus.SetFormatter(&logging.TraceFormatter{
+		Formatter: &logrus.TextFormatter{
+			DisableColors: true,
+		},
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel, on the
+	// -admin address
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "servicea")
 
@@ -109,86 +133,224 @@ func main() {
 		port = "8080"
 	}
 
-	je, err := jaeger.NewExporter(jaeger.Options{
-		CollectorEndpoint: "http://localhost:14268/api/traces",
-		Process: jaeger.Process{
-			ServiceName: "servicea",
-			Tags: []jaeger.Tag{
-				jaeger.StringTag("server", "1"), // could be hostname
-				jaeger.StringTag("port", port),
-				jaeger.BoolTag("demo", true),
+	// tracing is configured with flags, or their TRACE_* env vars
+	sc := sampling.DefaultConfig
+	if err := sc.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	var tc tailsample.Config
+	if err := tc.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	adminAddr, ok := os.LookupEnv("ADMIN_ADDR")
+	if !ok {
+		adminAddr = "localhost:9080"
+	}
+	admin := flag.String("admin", adminAddr, "`address` serving /debug/loglevel, /debug/sampler and the zPages (/debug/tracez & /debug/rpcz), disabled if empty")
+	inject := propagation.RegisterFlag(flag.CommandLine)
+	var bp baggage.Policy
+	if err := bp.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	exporter := spanfile.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	// with -trace-tail sample everything, as tail sampling only sees the
+	// sampled spans
+	if tc.Enabled && (sc.Default.Kind != sampling.Always || len(sc.Routes) > 0 || sc.ParentBased) {
+		log.Warn("Tail sampling: sampling every trace, ignoring -trace-sampler, -trace-sampler-routes & -trace-sampler-parent")
+		sc = sampling.Config{Default: sampling.Rule{Kind: sampling.Always}, DebugHeader: sc.DebugHeader}
+	}
+
+	// Expose build & runtime info, including the effective configuration:
+	// the flags, as parsed, and what isn't configurable
+	info, err := buildinfo.New(buildinfo.Flags(flag.CommandLine, map[string]string{"port": port, "serviceb_url": serviceBURL}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	prometheus.MustRegister(info)
+	expvar.Publish("BuildInfo", info)
+
+	// export spans to Jaeger, or to stdout or a file with -trace-exporter
+	var exp trace.Exporter
+	if *exporter == "jaeger" {
+		je, err := jaeger.NewExporter(jaeger.Options{
+			CollectorEndpoint: "http://localhost:14268/api/traces",
+			Process: jaeger.Process{
+				ServiceName: "servicea",
+				Tags: append(info.JaegerTags(),
+					jaeger.BoolTag("demo", true),
+				),
 			},
-		},
-	})
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+		}
+		defer je.Flush()
+		exp = je
+	} else {
+		fe, err := spanfile.New(*exporter, spanfile.Options{})
+		if err != nil {
+			log.Fatalf("Failed to create the span file exporter: %v", err)
+		}
+		defer fe.Close()
+		exp = fe
+	}
+	// with -trace-tail only export traces with errors or a slow root, and a
+	// few of the others
+	if tc.Enabled {
+		ts := tailsample.New(exp, tc.Options)
+		defer ts.Close()
+		prometheus.MustRegister(ts)
+		exp = ts
+	}
+	trace.RegisterExporter(exp) //register the exporter
+
+	// extract any of W3C Trace Context, B3 & B3 single header, inject the
+	// -trace-propagation formats
+	pf, err := propagation.New(*inject)
 	if err != nil {
-		log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+		log.Fatal(err)
 	}
-	trace.RegisterExporter(je)                                            //register the exporter
-	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()}) // demo, so always sample
 
-	// Expose the port value
-	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "program_info",
-		Help: "Info about the program.",
-	},
-		[]string{"port"},
-	)
-	prometheus.MustRegister(info)
-	info.WithLabelValues(port).Set(1)
+	// sample according to the -trace-sampler* flags
+	sampler := sampling.New(sc)
+	trace.ApplyConfig(trace.Config{DefaultSampler: sampler.Sampler()})
+
+	// Chosen because the range is 00-300 ms
+	durBuckets := []float64{.025, .05, .075, .1, .125, .15, .175, .2, .225, .250, .275, .300}
 
 	durs := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name: "http_request_duration_seconds",
-			Help: "HTTP request duration.",
-			// Chosen because the range is 00-300 ms
-			Buckets: []float64{.025, .05, .075, .1, .125, .15, .175, .2, .225, .250, .275, .300},
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request duration.",
+			Buckets: durBuckets,
 		},
 		[]string{"handler", "code"},
 	)
 	prometheus.MustRegister(durs)
-	durs.WithLabelValues("regularWork", strconv.Itoa(http.StatusOK))
-	durs.WithLabelValues("regularWork", strconv.Itoa(http.StatusBadRequest))
-	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusOK))
-	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusBadRequest))
 
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-
-	var oct ochttp.Transport
-	c := http.Client{Transport: &oct, Timeout: 2 * time.Second} // always set sensible values for your service, never trust the defaults
-	mux.Handle("/",
-		ochttp.WithRouteTag(
-			http.HandlerFunc(
-				promhttp.InstrumentHandlerDuration(
-					durs.MustCurryWith(prometheus.Labels{"handler": "queryServiceB"}),
-					http.HandlerFunc(queryServiceBHandler(&c, serviceBURL)),
-				),
-			),
-			"/",
-		),
+	sizes := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "HTTP response size.",
+			Buckets: prometheus.ExponentialBuckets(4, 2, 8), // 4B..512B
+		},
+		[]string{"handler", "code"},
 	)
+	prometheus.MustRegister(sizes)
 
-	mux.Handle("/slow",
-		ochttp.WithRouteTag(
-			http.HandlerFunc(
-				promhttp.InstrumentHandlerDuration(
-					durs.MustCurryWith(prometheus.Labels{"handler": "slowLocalWork"}),
-					http.HandlerFunc(slowLocalWork),
-				),
-			),
-			"/slow",
-		),
-	)
+	// aggregate the stats recorded by ochttp's server & client
+	// instrumentation and expose them on /metrics too, as opencensus_io_http_*
+	if err := view.Register(ochttp.DefaultServerViews...); err != nil {
+		log.Fatal(err)
+	}
+	if err := view.Register(ochttp.DefaultClientViews...); err != nil {
+		log.Fatal(err)
+	}
+	ocreg := prometheus.NewRegistry()
+	if _, err := ocprom.NewExporter(ocprom.Options{Registry: ocreg}); err != nil {
+		log.Fatal(err)
+	}
 
-	log.Info("Listening at: http://localhost:" + port)
-	var pf b3.HTTPFormat
-	if err := http.ListenAndServe(
-		":"+port,
-		&ochttp.Handler{
-			Handler:     mux,
-			Propagation: &pf,
+	mux := http.NewServeMux()
+
+	// register the routes, initializing the durs & sizes series of each
+	// handler for the status codes it's expected to return
+	routes := &promroute.Table{
+		Mux:   mux,
+		Codes: []int{http.StatusOK, http.StatusBadRequest},
+		Vecs:  []prometheus.ObserverVec{durs, sizes},
+	}
+	mux.Handle("/debug/vars", expvar.Handler())
+	sampler.Route = func(r *http.Request) string { // per route rules use the mux's patterns
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, ocreg}, promhttp.HandlerOpts{
+			EnableOpenMetrics: true, // needed to expose the trace_id exemplars
+		}),
+	))
+
+	// instrument the calls made to serviceb
+	cm := promclient.NewMetrics(durBuckets)
+	prometheus.MustRegister(cm)
+
+	// send the -baggage-keys tags on to serviceb, and set the client attributes
+	// on the client spans started by ochttp
+	oct := ochttp.Transport{
+		Base:        &semconv.Transport{Base: &baggage.Transport{Policy: &bp}, Service: "serviceb"},
+		Propagation: pf,
+	}
+	c := http.Client{
+		Transport: &promclient.Transport{Base: &oct, Service: "serviceb", Metrics: cm},
+		Timeout:   2 * time.Second, // always set sensible values for your service, never trust the defaults
+	}
+	// errorResponse returns a 500 when serviceb can't be queried
+	routes.Handle("/", "queryServiceB", &red.Handler{
+		Route:     "/",
+		Log:       log,
+		Durations: routes.Curry(durs, "queryServiceB"),
+		Sizes:     routes.Curry(sizes, "queryServiceB"),
+		Handler:   http.HandlerFunc(queryServiceBHandler(&c, serviceBURL)),
+	}, http.StatusInternalServerError)
+
+	routes.Handle("/slow", "slowLocalWork", &red.Handler{
+		Route:     "/slow",
+		Log:       log,
+		Durations: routes.Curry(durs, "slowLocalWork"),
+		Sizes:     routes.Curry(sizes, "slowLocalWork"),
+		Handler:   http.HandlerFunc(slowLocalWork),
+	})
+
+	if err := routes.Check(); err != nil {
+		log.Fatal(err)
+	}
+
+	// serve the endpoints that shouldn't be public on a separate admin
+	// address: changing the log level, the sampler's configuration, and the
+	// zPages showing running spans, sample spans by latency and errored spans
+	// per span name, no collector needed
+	if *admin != "" {
+		am := http.NewServeMux()
+		am.Handle("/debug/loglevel", levels)
+		am.Handle("/debug/sampler", sampler)
+		zpages.Handle(am, "/debug")
+		go func() {
+			log.Info("zPages at: http://" + *admin + "/debug/tracez")
+			log.Fatal(http.ListenAndServe(*admin, am))
+		}()
+	}
+
+	srv := &http.Server{
+		Addr: ":" + port,
+		Handler: &ochttp.Handler{
+			Handler:         &baggage.Handler{Handler: mux, Policy: &bp}, // tags from the baggage header
+			Propagation:     pf,
+			GetStartOptions: sampler.StartOptions,
 		},
-	); err != nil {
+	}
+
+	// on SIGINT & SIGTERM finish the requests in flight and return, so that the
+	// deferred exporter closes export the buffered spans
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		log.Info("Shutting down on ", <-sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Error("Shutting down: " + err.Error())
+		}
+	}()
+
+	log.Info("Listening at: http://localhost:" + port)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal("Errored with: " + err.Error())
 	}
+	<-stopped
 }
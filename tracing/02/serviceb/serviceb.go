@@ -1,58 +1,144 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"flag"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/jaeger"
+	ocprom "contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/freeformz/goobser/internal/baggage"
+	"github.com/freeformz/goobser/internal/buildinfo"
+	"github.com/freeformz/goobser/internal/logging"
+	"github.com/freeformz/goobser/internal/loglevel"
+	"github.com/freeformz/goobser/internal/promroute"
+	"github.com/freeformz/goobser/internal/propagation"
+	"github.com/freeformz/goobser/internal/red"
+	"github.com/freeformz/goobser/internal/sampling"
+	"github.com/freeformz/goobser/internal/semconv"
+	"github.com/freeformz/goobser/internal/spanfile"
+	"github.com/freeformz/goobser/internal/tailsample"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ochttp"
-	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
+	"go.opencensus.io/zpages"
 )
 
+// OpenCensus measures of the pretend work, recorded with the route (set in the
+// tag map by red.Handler) and outcome tags.
+var (
+	keyOutcome = tag.MustNewKey("outcome") // hit or miss, uncached for the slow work
+
+	workLatency  = stats.Float64("serviceb/work/latency", "Latency of the pretend work", stats.UnitMilliseconds)
+	cacheLookups = stats.Int64("serviceb/cache/lookups", "Pretend cache lookups", stats.UnitDimensionless)
+)
+
+// workViews aggregate the pretend work measures.
+var workViews = []*view.View{
+	{
+		Name:        "serviceb/work/count",
+		Description: "Count of the pretend work, by route and outcome",
+		Measure:     workLatency,
+		TagKeys:     []tag.Key{ochttp.KeyServerRoute, keyOutcome},
+		Aggregation: view.Count(),
+	},
+	{
+		Name:        "serviceb/work/total_latency",
+		Description: "Total latency of the pretend work, by route and outcome",
+		Measure:     workLatency,
+		TagKeys:     []tag.Key{ochttp.KeyServerRoute, keyOutcome},
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "serviceb/work/latency",
+		Description: "Latency distribution of the pretend work, by route and outcome",
+		Measure:     workLatency,
+		TagKeys:     []tag.Key{ochttp.KeyServerRoute, keyOutcome},
+		Aggregation: view.Distribution(25, 50, 75, 100, 125, 150, 175, 200, 225, 250, 275, 300),
+	},
+	{
+		Name:        "serviceb/cache/lookups",
+		Description: "Count of the pretend cache lookups, by route and outcome",
+		Measure:     cacheLookups,
+		TagKeys:     []tag.Key{ochttp.KeyServerRoute, keyOutcome},
+		Aggregation: view.Count(),
+	},
+}
+
+// recordWork records the latency of the work started at start with the
+// outcome tag added to ctx's tags.
+func recordWork(ctx context.Context, start time.Time, outcome string, ms ...stats.Measurement) {
+	ctx, err := tag.New(ctx, tag.Upsert(keyOutcome, outcome))
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("tagging work")
+		return
+	}
+	ms = append(ms, workLatency.M(float64(time.Since(start))/float64(time.Millisecond)))
+	stats.Record(ctx, ms...)
+}
+
 func workHandler(w http.ResponseWriter, r *http.Request) { // pretend work
-	_, span := trace.StartSpan(r.Context(), "workHandler")
+	ctx, span := trace.StartSpan(r.Context(), "workHandler")
 	defer span.End()
+	semconv.Server(span, r, "/")
+	start := time.Now()
 
 	s := rand.Intn(99) + 1 // 1..100
-	span.Annotate([]trace.Attribute{
-		trace.Int64Attribute("s", int64(s)),
-	}, "")
+	span.AddAttributes(trace.Int64Attribute("s", int64(s)))
 
 	time.Sleep(time.Duration(s) * time.Millisecond)
 
 	switch {
 	case s <= 25:
+		recordWork(ctx, start, "miss", cacheLookups.M(1))
+		logging.FromContext(ctx).WithField("s", s).Warn("cache miss")
+		semconv.Response(span, http.StatusBadRequest)
 		http.Error(w, "cache miss", http.StatusBadRequest)
 	default:
+		recordWork(ctx, start, "hit", cacheLookups.M(1))
+		semconv.Response(span, http.StatusOK)
 		w.Write([]byte(`b = :-) `))
 	}
 }
 
 func slowWorkHandler(w http.ResponseWriter, r *http.Request) { // slow pretend work
-	_, span := trace.StartSpan(r.Context(), "slowWorkHandler")
+	ctx, span := trace.StartSpan(r.Context(), "slowWorkHandler")
 	defer span.End()
+	semconv.Server(span, r, "/slow")
+	start := time.Now()
 
 	s := 100 + rand.Intn(200) // 100..300
-	span.Annotate([]trace.Attribute{
-		trace.Int64Attribute("s", int64(s)),
-	}, "")
+	span.AddAttributes(trace.Int64Attribute("s", int64(s)))
 	time.Sleep(time.Duration(s) * time.Millisecond)
+	recordWork(ctx, start, "uncached")
 
+	semconv.Response(span, http.StatusOK)
 	w.Write([]byte(`b = 🐢 `))
 }
 
 func main() {
-	logrus.SetFormatter(&logrus.TextFormatter{
-		DisableColors: true,
+	// add the trace_id, span_id & sampled fields of the current span to logs
+	logrus.SetFormatter(&logging.TraceFormatter{
+		Formatter: &logrus.TextFormatter{
+			DisableColors: true,
+		},
 	})
 
+	// allow the log level to be changed at runtime via /debug/loglevel, on the
+	// -admin address
+	levels := loglevel.New(logrus.StandardLogger())
+
 	// curried log
 	log := logrus.WithField("app", "serviceb")
 
@@ -61,32 +147,89 @@ func main() {
 		port = "8081"
 	}
 
-	je, err := jaeger.NewExporter(jaeger.Options{
-		CollectorEndpoint: "http://localhost:14268/api/traces",
-		Process: jaeger.Process{
-			ServiceName: "serviceb",
-			Tags: []jaeger.Tag{
-				jaeger.StringTag("server", "1"), // could be hostname
-				jaeger.StringTag("port", port),
-				jaeger.BoolTag("demo", true),
+	// tracing is configured with flags, or their TRACE_* env vars
+	sc := sampling.DefaultConfig
+	if err := sc.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	var tc tailsample.Config
+	if err := tc.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	adminAddr, ok := os.LookupEnv("ADMIN_ADDR")
+	if !ok {
+		adminAddr = "localhost:9081"
+	}
+	admin := flag.String("admin", adminAddr, "`address` serving /debug/loglevel, /debug/sampler and the zPages (/debug/tracez & /debug/rpcz), disabled if empty")
+	inject := propagation.RegisterFlag(flag.CommandLine)
+	var bp baggage.Policy
+	if err := bp.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	exporter := spanfile.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	// with -trace-tail sample everything, as tail sampling only sees the
+	// sampled spans
+	if tc.Enabled && (sc.Default.Kind != sampling.Always || len(sc.Routes) > 0 || sc.ParentBased) {
+		log.Warn("Tail sampling: sampling every trace, ignoring -trace-sampler, -trace-sampler-routes & -trace-sampler-parent")
+		sc = sampling.Config{Default: sampling.Rule{Kind: sampling.Always}, DebugHeader: sc.DebugHeader}
+	}
+
+	// Expose build & runtime info, including the effective configuration:
+	// the flags, as parsed, and what isn't configurable
+	info, err := buildinfo.New(buildinfo.Flags(flag.CommandLine, map[string]string{"port": port}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	prometheus.MustRegister(info)
+	expvar.Publish("BuildInfo", info)
+
+	// export spans to Jaeger, or to stdout or a file with -trace-exporter
+	var exp trace.Exporter
+	if *exporter == "jaeger" {
+		je, err := jaeger.NewExporter(jaeger.Options{
+			CollectorEndpoint: "http://localhost:14268/api/traces",
+			Process: jaeger.Process{
+				ServiceName: "serviceb",
+				Tags: append(info.JaegerTags(),
+					jaeger.BoolTag("demo", true),
+				),
 			},
-		},
-	})
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+		}
+		defer je.Flush()
+		exp = je
+	} else {
+		fe, err := spanfile.New(*exporter, spanfile.Options{})
+		if err != nil {
+			log.Fatalf("Failed to create the span file exporter: %v", err)
+		}
+		defer fe.Close()
+		exp = fe
+	}
+	// with -trace-tail only export traces with errors or a slow root, and a
+	// few of the others
+	if tc.Enabled {
+		ts := tailsample.New(exp, tc.Options)
+		defer ts.Close()
+		prometheus.MustRegister(ts)
+		exp = ts
+	}
+	trace.RegisterExporter(exp) //register the exporter
+
+	// extract any of W3C Trace Context, B3 & B3 single header, inject the
+	// -trace-propagation formats
+	pf, err := propagation.New(*inject)
 	if err != nil {
-		log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+		log.Fatal(err)
 	}
-	trace.RegisterExporter(je)                                            //register the exporter
-	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()}) // demo, so always sample
 
-	// Expose the port value
-	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "program_info",
-		Help: "Info about the program.",
-	},
-		[]string{"port"},
-	)
-	prometheus.MustRegister(info)
-	info.WithLabelValues(port).Set(1)
+	// sample according to the -trace-sampler* flags
+	sampler := sampling.New(sc)
+	trace.ApplyConfig(trace.Config{DefaultSampler: sampler.Sampler()})
 
 	durs := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -98,47 +241,117 @@ func main() {
 		[]string{"handler", "code"},
 	)
 	prometheus.MustRegister(durs)
-	durs.WithLabelValues("regularWork", strconv.Itoa(http.StatusOK))
-	durs.WithLabelValues("regularWork", strconv.Itoa(http.StatusBadRequest))
-	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusOK))
-	durs.WithLabelValues("slowWork", strconv.Itoa(http.StatusBadRequest))
 
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-
-	mux.Handle("/",
-		ochttp.WithRouteTag(
-			http.HandlerFunc(
-				promhttp.InstrumentHandlerDuration(
-					durs.MustCurryWith(prometheus.Labels{"handler": "regularWork"}),
-					http.HandlerFunc(workHandler),
-				),
-			),
-			"/",
-		),
+	sizes := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "HTTP response size.",
+			Buckets: prometheus.ExponentialBuckets(4, 2, 8), // 4B..512B
+		},
+		[]string{"handler", "code"},
 	)
+	prometheus.MustRegister(sizes)
 
-	mux.Handle("/slow",
-		ochttp.WithRouteTag(
-			http.HandlerFunc(
-				promhttp.InstrumentHandlerDuration(
-					durs.MustCurryWith(prometheus.Labels{"handler": "slowWork"}),
-					http.HandlerFunc(slowWorkHandler),
-				),
-			),
-			"/slow",
-		),
-	)
+	// aggregate the stats recorded by ochttp's server instrumentation and by
+	// the handlers, and expose them on /metrics too, as opencensus_io_http_*
+	// and serviceb_*
+	if err := view.Register(ochttp.DefaultServerViews...); err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range workViews { // break the work down by the -baggage-keys tags too
+		v.TagKeys = append(v.TagKeys, bp.Keys...)
+	}
+	if err := view.Register(workViews...); err != nil {
+		log.Fatal(err)
+	}
+	ocreg := prometheus.NewRegistry()
+	if _, err := ocprom.NewExporter(ocprom.Options{Registry: ocreg}); err != nil {
+		log.Fatal(err)
+	}
 
-	log.Info("Listening at: http://localhost:" + port)
-	var pf b3.HTTPFormat
-	if err := http.ListenAndServe(
-		":"+port,
-		&ochttp.Handler{
-			Handler:     mux,
-			Propagation: &pf,
+	mux := http.NewServeMux()
+
+	// register the routes, initializing the durs & sizes series of each
+	// handler for the status codes it's expected to return
+	routes := &promroute.Table{
+		Mux:   mux,
+		Codes: []int{http.StatusOK, http.StatusBadRequest},
+		Vecs:  []prometheus.ObserverVec{durs, sizes},
+	}
+	mux.Handle("/debug/vars", expvar.Handler())
+	sampler.Route = func(r *http.Request) string { // per route rules use the mux's patterns
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, ocreg}, promhttp.HandlerOpts{
+			EnableOpenMetrics: true, // needed to expose the trace_id exemplars
+		}),
+	))
+
+	routes.Handle("/", "regularWork", &red.Handler{
+		Route:     "/",
+		Log:       log,
+		Durations: routes.Curry(durs, "regularWork"),
+		Sizes:     routes.Curry(sizes, "regularWork"),
+		Handler:   http.HandlerFunc(workHandler),
+	})
+
+	routes.Handle("/slow", "slowWork", &red.Handler{
+		Route:     "/slow",
+		Log:       log,
+		Durations: routes.Curry(durs, "slowWork"),
+		Sizes:     routes.Curry(sizes, "slowWork"),
+		Handler:   http.HandlerFunc(slowWorkHandler),
+	})
+
+	if err := routes.Check(); err != nil {
+		log.Fatal(err)
+	}
+
+	// serve the endpoints that shouldn't be public on a separate admin
+	// address: changing the log level, the sampler's configuration, and the
+	// zPages showing running spans, sample spans by latency and errored spans
+	// per span name, no collector needed
+	if *admin != "" {
+		am := http.NewServeMux()
+		am.Handle("/debug/loglevel", levels)
+		am.Handle("/debug/sampler", sampler)
+		zpages.Handle(am, "/debug")
+		go func() {
+			log.Info("zPages at: http://" + *admin + "/debug/tracez")
+			log.Fatal(http.ListenAndServe(*admin, am))
+		}()
+	}
+
+	srv := &http.Server{
+		Addr: ":" + port,
+		Handler: &ochttp.Handler{
+			Handler:         &baggage.Handler{Handler: mux, Policy: &bp}, // tags from the baggage header
+			Propagation:     pf,
+			GetStartOptions: sampler.StartOptions,
 		},
-	); err != nil {
+	}
+
+	// on SIGINT & SIGTERM finish the requests in flight and return, so that the
+	// deferred exporter closes export the buffered spans
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		log.Info("Shutting down on ", <-sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Error("Shutting down: " + err.Error())
+		}
+	}()
+
+	log.Info("Listening at: http://localhost:" + port)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal("Errored with: " + err.Error())
 	}
+	<-stopped
 }
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"flag"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/jaeger"
+	"github.com/freeformz/goobser/internal/propagation"
+	"github.com/freeformz/goobser/internal/sampling"
+	"github.com/freeformz/goobser/internal/spanfile"
 	"github.com/pkg/errors"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/trace"
@@ -64,25 +69,64 @@ func doAPIRequest(ctx context.Context, c *http.Client, url string) error {
 }
 
 func main() {
-	je, err := jaeger.NewExporter(jaeger.Options{
-		CollectorEndpoint: "http://localhost:14268/api/traces",
-		Process: jaeger.Process{
-			ServiceName: "client",
-			Tags: []jaeger.Tag{
-				jaeger.StringTag("client_id", "workshop"),
-				jaeger.BoolTag("client", true),
-				jaeger.BoolTag("demo", true),
+	// tracing is configured with flags, or their TRACE_* env vars
+	sc := sampling.DefaultConfig
+	if err := sc.RegisterFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	admin := flag.String("admin", os.Getenv("ADMIN_ADDR"), "`address` serving /debug/sampler, disabled if empty")
+	inject := propagation.RegisterFlag(flag.CommandLine)
+	exporter := spanfile.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	// export spans to Jaeger, or to stdout or a file with -trace-exporter
+	if *exporter == "jaeger" {
+		je, err := jaeger.NewExporter(jaeger.Options{
+			CollectorEndpoint: "http://localhost:14268/api/traces",
+			Process: jaeger.Process{
+				ServiceName: "client",
+				Tags: []jaeger.Tag{
+					jaeger.StringTag("client_id", "workshop"),
+					jaeger.BoolTag("client", true),
+					jaeger.BoolTag("demo", true),
+				},
 			},
-		},
-	})
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+		}
+		trace.RegisterExporter(je) //register the exporter
+	} else {
+		fe, err := spanfile.New(*exporter, spanfile.Options{})
+		if err != nil {
+			log.Fatalf("Failed to create the span file exporter: %v", err)
+		}
+		defer fe.Close()
+		trace.RegisterExporter(fe)
+	}
+
+	// extract any of W3C Trace Context, B3 & B3 single header, inject the
+	// -trace-propagation formats
+	pf, err := propagation.New(*inject)
 	if err != nil {
-		log.Fatalf("Failed to create the Jaeger exporter: %v", err)
+		log.Fatal(err)
+	}
+
+	// sample according to the -trace-sampler* flags
+	sampler := sampling.New(sc)
+	trace.ApplyConfig(trace.Config{DefaultSampler: sampler.Sampler()})
+	log.Printf("sampling with %+v\n", sc)
+
+	if *admin != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/sampler", sampler)
+		go func() {
+			log.Fatal(http.ListenAndServe(*admin, mux))
+		}()
 	}
-	trace.RegisterExporter(je)                                            //register the exporter
-	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()}) // demo, so always sample
 
 	ctx := context.Background()
-	var oct ochttp.Transport
+	oct := ochttp.Transport{Propagation: pf}
 	client := http.Client{Transport: &oct}
 
 	for {